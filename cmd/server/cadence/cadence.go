@@ -29,17 +29,55 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	cconfig "github.com/uber/cadence/common/config"
+	clog "github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/loggerimpl"
 	"github.com/urfave/cli"
 
 	"github.com/cadence-oss/cadence-notification/common/config"
+	"github.com/cadence-oss/cadence-notification/common/signature"
 	"github.com/cadence-oss/cadence-notification/service"
 )
 
+const defaultReceiverListenAddress = ":8801"
+
+// testReceiver is the built-in webhook endpoint for testing
+type testReceiver struct {
+	// signatures are verified if not empty
+	secrets            [][]byte
+	signatureTolerance time.Duration
+}
+
 // startHandler is the handler for the cli start command
 func startHandler(c *cli.Context) {
+	cfg := loadConfig(c)
+	log.Printf("loaded config=\n%v\n", cfg.String())
+	if err := service.ValidateConfig(cfg); err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
+
+	logger := newLogger(cfg)
+
+	metricScope := cfg.Service.Metrics.NewScope(logger, "cadence-notification")
+
+	reloader := &service.ConfigReloader{
+		Load: func() (*config.Config, error) {
+			var cfg config.Config
+			err := cconfig.Load(getEnvironment(c), getConfigDir(c), getZone(c), &cfg)
+			return &cfg, err
+		},
+		Dir: getConfigDir(c),
+	}
+	svc, err := service.NewService(cfg, reloader, logger, metricScope)
+	if err != nil {
+		log.Fatal("fail to create service", err)
+	}
+	svc.Start()
+}
+
+func loadConfig(c *cli.Context) *config.Config {
 	env := getEnvironment(c)
 	zone := getZone(c)
 	configDir := getConfigDir(c)
@@ -51,37 +89,65 @@ func startHandler(c *cli.Context) {
 	if err != nil {
 		log.Fatal("Config file corrupted.", err)
 	}
-	log.Printf("loaded config=\n%v\n", cfg.String())
+	return &cfg
+}
 
+func newLogger(cfg *config.Config) clog.Logger {
 	zapLogger, err := cfg.Log.NewZapLogger()
 	if err != nil {
 		log.Fatal("failed to create the zap logger, err: ", err.Error())
 	}
-	logger := loggerimpl.NewLogger(zapLogger)
+	return loggerimpl.NewLogger(zapLogger)
+}
 
-	metricScope := cfg.Service.Metrics.NewScope(logger, "cadence-notification")
+// validateConfigHandler is the handler for the cli validate-config command
+func validateConfigHandler(c *cli.Context) {
+	env := getEnvironment(c)
+	zone := getZone(c)
+	configDir := getConfigDir(c)
 
-	svc, err := service.NewService(&cfg, logger, metricScope)
+	var cfg config.Config
+	if err := cconfig.Load(env, configDir, zone, &cfg); err != nil {
+		fmt.Printf("failed to load config; env=%v,zone=%v,configDir=%v: %v\n", env, zone, configDir, err)
+		os.Exit(1)
+	}
+	err := service.ValidateConfig(&cfg)
+	if configErrs, ok := err.(service.ConfigErrors); ok {
+		fmt.Printf("found %v problem(s) in config; env=%v,zone=%v,configDir=%v\n", len(configErrs), env, zone, configDir)
+		for _, configErr := range configErrs {
+			fmt.Printf("  - %v\n", configErr)
+		}
+		os.Exit(1)
+	}
 	if err != nil {
-		log.Fatal("fail to create service", err)
+		fmt.Printf("failed to validate config; env=%v,zone=%v,configDir=%v: %v\n", env, zone, configDir, err)
+		os.Exit(1)
 	}
-	svc.Start()
+	fmt.Printf("config is valid; env=%v,zone=%v,configDir=%v\n", env, zone, configDir)
+}
+
+// getFlag returns the flag of the command if set, otherwise the global flag
+func getFlag(c *cli.Context, name string) string {
+	if c.IsSet(name) {
+		return c.String(name)
+	}
+	return c.GlobalString(name)
 }
 
 func getEnvironment(c *cli.Context) string {
-	return strings.TrimSpace(c.GlobalString("env"))
+	return strings.TrimSpace(getFlag(c, "env"))
 }
 
 func getZone(c *cli.Context) string {
-	return strings.TrimSpace(c.GlobalString("zone"))
+	return strings.TrimSpace(getFlag(c, "zone"))
 }
 
 func getConfigDir(c *cli.Context) string {
-	return constructPathIfNeed(getRootDir(c), c.GlobalString("config"))
+	return constructPathIfNeed(getRootDir(c), getFlag(c, "config"))
 }
 
 func getRootDir(c *cli.Context) string {
-	dirpath := c.GlobalString("root")
+	dirpath := getFlag(c, "root")
 	if len(dirpath) == 0 {
 		cwd, err := os.Getwd()
 		if err != nil {
@@ -108,33 +174,7 @@ func BuildCLI() *cli.App {
 	app.Usage = "Cadence notification service"
 	app.Version = "beta"
 
-	app.Flags = []cli.Flag{
-		cli.StringFlag{
-			Name:   "root, r",
-			Value:  ".",
-			Usage:  "root directory of execution environment",
-			EnvVar: cconfig.EnvKeyRoot,
-		},
-		cli.StringFlag{
-			Name:   "config, c",
-			Value:  "config",
-			Usage:  "config dir is a path relative to root, or an absolute path",
-			EnvVar: cconfig.EnvKeyConfigDir,
-		},
-		cli.StringFlag{
-			Name:   "env, e",
-			Value:  "development",
-			Usage:  "runtime environment",
-			EnvVar: cconfig.EnvKeyEnvironment,
-		},
-		cli.StringFlag{
-			Name:   "zone, az",
-			Value:  "",
-			Usage:  "availability zone",
-			EnvVar: cconfig.EnvKeyAvailabilityZone,
-		},
-	}
-
+	app.Flags = newConfigFlags()
 	app.Commands = []cli.Command{
 		{
 			Name:    "start",
@@ -159,17 +199,57 @@ func BuildCLI() *cli.App {
 				wg.Wait()
 			},
 		},
+		{
+			Name:   "validate-config",
+			Usage:  "validate the config and print all the problems found",
+			Flags:  newConfigFlags(),
+			Action: validateConfigHandler,
+		},
+		newSubscriberCommand(),
+		newReplayCommand(),
+		newDLQCommand(),
 	}
 	return app
 }
 
+// newConfigFlags returns the flags for locating the config, they can be used both globally and per command
+func newConfigFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "root, r",
+			Value:  ".",
+			Usage:  "root directory of execution environment",
+			EnvVar: cconfig.EnvKeyRoot,
+		},
+		cli.StringFlag{
+			Name:   "config, c",
+			Value:  "config",
+			Usage:  "config dir is a path relative to root, or an absolute path",
+			EnvVar: cconfig.EnvKeyConfigDir,
+		},
+		cli.StringFlag{
+			Name:   "env, e",
+			Value:  "development",
+			Usage:  "runtime environment",
+			EnvVar: cconfig.EnvKeyEnvironment,
+		},
+		cli.StringFlag{
+			Name:   "zone, az",
+			Value:  "",
+			Usage:  "availability zone",
+			EnvVar: cconfig.EnvKeyAvailabilityZone,
+		},
+	}
+
+}
+
 func launchService(service string, c *cli.Context) {
 	switch service {
 	case "notifier":
 		startHandler(c)
 		break
 	case "receiver":
-		startTestWebhookEndpoint()
+		startTestWebhookEndpoint(c)
 		break
 	default:
 		log.Printf("Invalid service: %v", service)
@@ -193,17 +273,29 @@ func getServices(c *cli.Context) []string {
 	return services
 }
 
-func startTestWebhookEndpoint() {
-	http.HandleFunc("/", logIncomingRequest)
+func startTestWebhookEndpoint(c *cli.Context) {
+	receiverConfig := loadConfig(c).Receiver
+	secrets, err := receiverConfig.Signing.LoadSecrets()
+	if err != nil {
+		log.Fatal("invalid receiver signing config: ", err)
+	}
+	receiver := &testReceiver{
+		secrets:            secrets,
+		signatureTolerance: receiverConfig.SignatureTolerance,
+	}
+	http.HandleFunc("/", receiver.logIncomingRequest)
 
-	fmt.Printf("Starting server for testing...\n")
-	// TODO make test webhook endpoint port configurable
-	if err := http.ListenAndServe(":8801", nil); err != nil {
+	listenAddress := receiverConfig.ListenAddress
+	if listenAddress == "" {
+		listenAddress = defaultReceiverListenAddress
+	}
+	fmt.Printf("Starting server for testing on %v, verifying signatures: %v...\n", listenAddress, len(secrets) > 0)
+	if err := http.ListenAndServe(listenAddress, nil); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func logIncomingRequest(w http.ResponseWriter, r *http.Request) {
+func (t *testReceiver) logIncomingRequest(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		log.Printf("Path not supported: %v", r.URL.Path)
 		http.Error(w, "404 not found.", http.StatusNotFound)
@@ -213,9 +305,19 @@ func logIncomingRequest(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
 		var body []byte
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("[Failed to read request body]: %v", err.Error())
+		var err error
+		if len(t.secrets) > 0 {
+			body, err = signature.VerifyRequest(r, t.secrets, t.signatureTolerance)
+			if err != nil {
+				log.Printf("[Rejected request with invalid signature]: %v", err.Error())
+				w.WriteHeader(http.StatusUnauthorized)
+				break
+			}
+		} else {
+			body, err = ioutil.ReadAll(r.Body)
+			if err != nil {
+				log.Printf("[Failed to read request body]: %v", err.Error())
+			}
 		}
 
 		log.Printf("[Test server incoming request]: %v, URL: %v", string(body), r.URL.Path)
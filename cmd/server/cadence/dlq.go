@@ -0,0 +1,161 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cadence
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/uber-go/tally"
+	"github.com/urfave/cli"
+
+	"github.com/cadence-oss/cadence-notification/service"
+)
+
+const defaultRedriveLedgerFile = "dlq-redrive-ledger.jsonl"
+
+func newDLQCommand() cli.Command {
+	filterFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "name, n",
+			Usage: "name of the subscriber",
+		},
+		cli.StringFlag{
+			Name:  "workflow-id",
+			Usage: "only entries of this workflow ID",
+		},
+		cli.IntFlag{
+			Name:  "status-code",
+			Usage: "only entries whose last delivery attempt got this status code",
+		},
+		cli.StringFlag{
+			Name:  "failed-after",
+			Usage: "only entries failed at or after this time, in RFC3339 format",
+		},
+		cli.StringFlag{
+			Name:  "failed-before",
+			Usage: "only entries failed before this time, in RFC3339 format",
+		},
+		cli.StringSliceFlag{
+			Name:  "entry, i",
+			Usage: "only this entry, as partition:offset of the DLQ topic. Can be repeated",
+		},
+		cli.StringFlag{
+			Name:  "ledger",
+			Value: defaultRedriveLedgerFile,
+			Usage: "file recording the successfully redriven entries, which are skipped by later redrives",
+		},
+	}
+	return cli.Command{
+		Name:  "dlq",
+		Usage: "inspect and redrive the entries of a subscriber's consumerGroupDlqTopic",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "print the matching entries as JSON lines, with their notification and failure details",
+				Flags:  filterFlags,
+				Action: dlqListHandler,
+			},
+			{
+				Name:  "redrive",
+				Usage: "deliver the matching entries through the subscriber's sink again, requires --entry or --all",
+				Flags: append([]cli.Flag{
+					cli.BoolFlag{
+						Name:  "all",
+						Usage: "redrive all entries matching the filters",
+					},
+					cli.Float64Flag{
+						Name:  "rate",
+						Value: 10,
+						Usage: "max redrives per second, 0 means unlimited",
+					},
+					cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "print the notifications to stdout instead of delivering them",
+					},
+				}, filterFlags...),
+				Action: dlqRedriveHandler,
+			},
+		},
+	}
+}
+
+// dlqListHandler is the handler for the cli dlq list command
+func dlqListHandler(c *cli.Context) {
+	options := getDLQOptions(c)
+	cfg := loadConfig(c)
+	ctx, cancel := newInterruptibleContext()
+	defer cancel()
+
+	encoder := json.NewEncoder(os.Stdout)
+	count := 0
+	err := service.ListDLQ(ctx, cfg, options, newLogger(cfg), func(entry *service.DLQEntry) error {
+		count++
+		return encoder.Encode(entry)
+	})
+	fmt.Fprintf(os.Stderr, "listed %v entries\n", count)
+	if err != nil {
+		log.Fatal("failed to list DLQ: ", err)
+	}
+}
+
+// dlqRedriveHandler is the handler for the cli dlq redrive command
+func dlqRedriveHandler(c *cli.Context) {
+	options := getDLQOptions(c)
+	if len(options.Entries) == 0 && !c.Bool("all") {
+		log.Fatal("either --entry or --all is required")
+	}
+	options.RateLimit = c.Float64("rate")
+	options.DryRun = c.Bool("dry-run")
+	options.Output = os.Stdout
+	options.Progress = os.Stderr
+
+	cfg := loadConfig(c)
+	ctx, cancel := newInterruptibleContext()
+	defer cancel()
+	result, err := service.RedriveDLQ(ctx, cfg, options, newLogger(cfg), tally.NoopScope)
+	if result != nil {
+		fmt.Fprintf(os.Stderr, "redrive: %v succeeded, %v failed, %v skipped as already redriven\n",
+			result.Succeeded, result.Failed, result.Skipped)
+	}
+	if err != nil {
+		log.Fatal("redrive failed: ", err)
+	}
+}
+
+func getDLQOptions(c *cli.Context) *service.DLQOptions {
+	name := strings.TrimSpace(c.String("name"))
+	if name == "" {
+		log.Fatal("--name is required")
+	}
+	return &service.DLQOptions{
+		Subscriber:   name,
+		WorkflowID:   strings.TrimSpace(c.String("workflow-id")),
+		StatusCode:   c.Int("status-code"),
+		FailedAfter:  parseTimeFlag(c, "failed-after"),
+		FailedBefore: parseTimeFlag(c, "failed-before"),
+		Entries:      c.StringSlice("entry"),
+		LedgerFile:   strings.TrimSpace(c.String("ledger")),
+	}
+}
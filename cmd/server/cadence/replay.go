@@ -0,0 +1,144 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cadence
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/uber-go/tally"
+	"github.com/urfave/cli"
+
+	"github.com/cadence-oss/cadence-notification/service"
+)
+
+func newReplayCommand() cli.Command {
+	return cli.Command{
+		Name:  "replay",
+		Usage: "re-deliver the notifications of an offset or time range to a subscriber, without changing its consumer group offsets",
+		Description: "Replay reads the partitions of the visibility topic directly. It doesn't join a consumer group and never commits " +
+			"offsets, so an interrupted replay isn't resumed, rerun it from the offsets of its progress output instead.",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name, n",
+				Usage: "name of the subscriber",
+			},
+			cli.IntSliceFlag{
+				Name:  "partition, p",
+				Usage: "partition of the visibility topic to replay, can be repeated. Default to all partitions",
+			},
+			cli.Int64Flag{
+				Name:  "start-offset",
+				Value: -1,
+				Usage: "first offset to replay, default to the oldest",
+			},
+			cli.Int64Flag{
+				Name:  "end-offset",
+				Value: -1,
+				Usage: "last offset to replay(inclusive), default to the newest",
+			},
+			cli.StringFlag{
+				Name:  "start-time",
+				Usage: "replay messages from this time, in RFC3339 format. Can't be used with offsets",
+			},
+			cli.StringFlag{
+				Name:  "end-time",
+				Usage: "replay messages before this time, in RFC3339 format. Can't be used with offsets",
+			},
+			cli.Float64Flag{
+				Name:  "rate",
+				Value: 10,
+				Usage: "max messages per second, 0 means unlimited",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "print the notifications to stdout instead of delivering them",
+			},
+		},
+		Action: replayHandler,
+	}
+}
+
+// replayHandler is the handler for the cli replay command
+func replayHandler(c *cli.Context) {
+	name := strings.TrimSpace(c.String("name"))
+	if name == "" {
+		log.Fatal("--name is required")
+	}
+	options := &service.ReplayOptions{
+		Subscriber:  name,
+		StartOffset: c.Int64("start-offset"),
+		EndOffset:   c.Int64("end-offset"),
+		StartTime:   parseTimeFlag(c, "start-time"),
+		EndTime:     parseTimeFlag(c, "end-time"),
+		RateLimit:   c.Float64("rate"),
+		DryRun:      c.Bool("dry-run"),
+		Output:      os.Stdout,
+		Progress:    os.Stderr,
+	}
+	for _, partition := range c.IntSlice("partition") {
+		options.Partitions = append(options.Partitions, int32(partition))
+	}
+
+	cfg := loadConfig(c)
+	ctx, cancel := newInterruptibleContext()
+	defer cancel()
+	result, err := service.Replay(ctx, cfg, options, newLogger(cfg), tally.NoopScope)
+	if result != nil {
+		fmt.Fprintf(os.Stderr, "replayed %v message(s): %v succeeded, %v failed\n", result.Processed, result.Succeeded, result.Failed)
+	}
+	if err != nil {
+		log.Fatal("replay failed: ", err)
+	}
+}
+
+func parseTimeFlag(c *cli.Context, name string) time.Time {
+	value := strings.TrimSpace(c.String(name))
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("invalid --%v: %v", name, err)
+	}
+	return t
+}
+
+// newInterruptibleContext returns a context that is canceled on SIGINT or SIGTERM
+func newInterruptibleContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signalC := make(chan os.Signal, 1)
+	signal.Notify(signalC, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signalC:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cadence
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+const adminRequestTimeout = 10 * time.Second
+
+func newSubscriberCommand() cli.Command {
+	flags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "name, n",
+			Usage: "name of the subscriber",
+		},
+		cli.StringFlag{
+			Name:  "address",
+			Usage: "address of the admin server, default to service.admin.listenAddress of the config",
+		},
+	}
+	newAction := func(action, usage string) cli.Command {
+		return cli.Command{
+			Name:  action,
+			Usage: usage,
+			Flags: flags,
+			Action: func(c *cli.Context) {
+				subscriberActionHandler(c, action, nil)
+			},
+		}
+	}
+	return cli.Command{
+		Name:  "subscriber",
+		Usage: "manage subscribers of a running notifier via its admin server",
+		Subcommands: []cli.Command{
+			newAction("pause", "stop taking new messages for the subscriber, messages pile up in Kafka"),
+			newAction("resume", "resume a paused or drained subscriber"),
+			newAction("drain", "finish the in-flight deliveries and then stop consuming, releasing the partitions to other instances"),
+			{
+				Name:  "limits",
+				Usage: "change the delivery limits of the subscriber until it's restarted or reloaded, omitted flags are unchanged",
+				Flags: append([]cli.Flag{
+					cli.Float64Flag{
+						Name:  "rps",
+						Usage: "max notifications(or batch requests) delivered per second, 0 means unlimited",
+					},
+					cli.IntFlag{
+						Name:  "burst",
+						Usage: "max notifications delivered at once after being idle, 0 means rps rounded up",
+					},
+					cli.IntFlag{
+						Name:  "max-in-flight",
+						Usage: "max deliveries(or batch requests) in progress, 0 means unlimited",
+					},
+				}, flags...),
+				Action: subscriberLimitsHandler,
+			},
+		},
+	}
+}
+
+// subscriberLimitsHandler is the handler for the cli subscriber limits command
+func subscriberLimitsHandler(c *cli.Context) {
+	update := make(map[string]interface{})
+	if c.IsSet("rps") {
+		update["rps"] = c.Float64("rps")
+	}
+	if c.IsSet("burst") {
+		update["burst"] = c.Int("burst")
+	}
+	if c.IsSet("max-in-flight") {
+		update["maxInFlight"] = c.Int("max-in-flight")
+	}
+	if len(update) == 0 {
+		log.Fatal("at least one of --rps, --burst and --max-in-flight is required")
+	}
+	body, err := json.Marshal(update)
+	if err != nil {
+		log.Fatal(err)
+	}
+	subscriberActionHandler(c, "limits", body)
+}
+
+// subscriberActionHandler posts the action to the admin server and prints the resulting subscriber state
+func subscriberActionHandler(c *cli.Context, action string, body []byte) {
+	name := strings.TrimSpace(c.String("name"))
+	if name == "" {
+		log.Fatal("--name is required")
+	}
+	address := strings.TrimSpace(c.String("address"))
+	if address == "" {
+		address = loadConfig(c).Service.Admin.ListenAddress
+		if address == "" {
+			log.Fatal("--address is required when service.admin.listenAddress is not configured")
+		}
+	}
+	if strings.HasPrefix(address, ":") {
+		address = "127.0.0.1" + address
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	httpClient := &http.Client{Timeout: adminRequestTimeout}
+	resp, err := httpClient.Post(fmt.Sprintf("%v/subscribers/%v/%v", address, name, action), "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("failed to %v subscriber %v: %v", action, name, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("failed to %v subscriber %v: %v %v", action, name, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	fmt.Println(string(respBody))
+}
@@ -37,6 +37,8 @@ type (
 		Service Service `yaml:"service"`
 		// Kafka is the config for connecting to kafka
 		Kafka cconfig.KafkaConfig `yaml:"kafka"`
+		// Receiver is the config of the built-in test receiver
+		Receiver Receiver `yaml:"receiver"`
 	}
 
 	// Service contains the service specific config items
@@ -45,7 +47,36 @@ type (
 		Metrics cconfig.Metrics `yaml:"metrics"`
 		// Subscribers is the config for delivering notifications to different subscribers
 		Subscribers []Subscriber `yaml:"subscribers"`
- 	}
+		// DomainResolver is the config for resolving domain names used in subscriber filters
+		DomainResolver DomainResolver `yaml:"domainResolver"`
+		// Admin is the config of the admin HTTP server
+		Admin Admin `yaml:"admin"`
+		// ConfigReload is the config for hot reloading subscribers, which is also triggered by SIGHUP
+		ConfigReload ConfigReload `yaml:"configReload"`
+	}
+
+	// ConfigReload defines how config changes are detected
+	ConfigReload struct {
+		// interval for checking the config directory for changes, default to 0 which means only reloading on SIGHUP
+		WatchInterval time.Duration `yaml:"watchInterval"`
+	}
+
+	// Admin defines the admin HTTP server serving health checks and the state of subscribers
+	Admin struct {
+		// address to listen on, e.g. ":8802". Empty means the admin server is disabled
+		ListenAddress string `yaml:"listenAddress"`
+		// local file persisting subscribers paused or drained via the admin server, so that they stay paused after restarts.
+		// Empty means the state is not persisted
+		StateFile string `yaml:"stateFile"`
+	}
+
+	// DomainResolver defines where the domain name/ID mapping is loaded from
+	DomainResolver struct {
+		// path of a YAML file with domain name -> domain ID entries, relative to the working directory or absolute
+		MappingFile string `yaml:"mappingFile"`
+		// interval for reloading the mapping, default to 1m. Unknown domains also reload it, at most once per 10s
+		RefreshInterval time.Duration `yaml:"refreshInterval"`
+	}
 
 	// Subscriber contains config to deliver notifications
 	Subscriber struct {
@@ -57,47 +88,232 @@ type (
 		Delivery Delivery `yaml:"delivery"`
 		// filtering notification
 		Filter Filter `yaml:"filter"`
+		// "raw"(default) sends the memo as the serialized Memo blob, which is a base64 string in JSON.
+		// "json" decodes it into a key -> value map, values are decoded as JSON if possible, otherwise kept as base64 strings
+		MemoEncoding string `yaml:"memoEncoding"`
+		// Dedup suppresses notifications that were delivered recently, e.g. visibility records emitted again
+		Dedup Dedup `yaml:"dedup"`
+		// "notification"(default) delivers the Notification as is,
+		// "cloudevents" wraps it in a CloudEvents 1.0 envelope, see CloudEvents
+		Format string `yaml:"format"`
+		// CloudEvents defines the envelope when format is "cloudevents"
+		CloudEvents CloudEvents `yaml:"cloudEvents"`
+	}
+
+	// CloudEvents defines how notifications are wrapped in CloudEvents
+	CloudEvents struct {
+		// "structured"(default) sends the whole event as JSON, "binary" sends the Notification as data
+		// and the event attributes as headers, "ce-*" for webhooks and "ce_*" for Kafka
+		Mode string `yaml:"mode"`
+		// name of the Cadence cluster in the source attribute, e.g. "/cadence/<cluster>/domains/<domain>".
+		// Omitted from the source if empty
+		Cluster string `yaml:"cluster"`
+	}
+
+	// Dedup defines the window of remembering the idempotency keys of delivered notifications
+	Dedup struct {
+		// how long a delivered notification is remembered, default to 0 which means no deduplication
+		Window time.Duration `yaml:"window"`
+		// max number of remembered notifications, the least recently used are forgotten first. Default to 100000
+		MaxKeys int `yaml:"maxKeys"`
+		// local file persisting the remembered notifications, so that they survive restarts. Empty means not persisted.
+		// It is an append-only log of JSON lines, compacted when it has twice as many entries as maxKeys
+		StateFile string `yaml:"stateFile"`
 	}
 
 	// KafkaConsumer defines a consumer from the Kafka topic
 	KafkaConsumer struct {
 		// Kafka consumer group name
-		ConsumerGroup string  `yaml:"consumerGroup"`
+		ConsumerGroup string `yaml:"consumerGroup"`
 		// Kafka topic to send DLQ after maxing out retries
-		ConsumerGroupDlqTopic string  `yaml:"consumerGroupDlqTopic"`
-		// "newest" or "oldest" for consumer group first time to consume
-		InitialOffset string  `yaml:"initialOffset"`
+		ConsumerGroupDlqTopic string `yaml:"consumerGroupDlqTopic"`
+		// where the consumer group starts when it consumes for the first time: "oldest"(default, warned about if not set), "newest",
+		// or a RFC3339 timestamp like "2026-10-01T00:00:00Z" to start from the first message at or after it.
+		// It has no effect once the consumer group has committed offsets
+		InitialOffset string `yaml:"initialOffset"`
 		// concurrency per app per host, default to 10
 		Concurrency int `yaml:"concurrency"`
+		// "workflowID" or "runID" to deliver the notifications of the same workflow(or run) in order,
+		// different workflows are still delivered concurrently. Default to no ordering
+		Ordering string `yaml:"ordering"`
 	}
 
 	// Delivery defines how to deliver the notification
 	Delivery struct {
-		// an enum that supports "webhook"
+		// name of a delivery method registered in the service package, default "webhook".
+		// Each method reads its own config block below.
 		Method string `yaml:"method"`
 		// required when method is "webhook", defines how to deliver notification via webhook
 		Webhook Webhook `yaml:"webhook"`
+		// required when method is "kafka", defines how to publish notification to a Kafka topic
+		Kafka KafkaDelivery `yaml:"kafka"`
+		// Limits caps the deliveries to the subscriber, also when replaying
+		Limits DeliveryLimits `yaml:"limits"`
+		// CircuitBreaker stops consuming while the receiver keeps failing
+		CircuitBreaker CircuitBreaker `yaml:"circuitBreaker"`
+	}
+
+	// CircuitBreaker opens after too many failed deliveries. While open nothing is delivered or consumed,
+	// until a trial delivery after openInterval succeeds
+	CircuitBreaker struct {
+		// ratio of failed delivery attempts in (0, 1] opening the circuit, default to 0 which disables the circuit breaker.
+		// Retries count as attempts, while responses with a status code that isn't retried(e.g. 400) don't count
+		FailureRatio float64 `yaml:"failureRatio"`
+		// min attempts within a window before the ratio is checked, default to 10
+		MinRequests int `yaml:"minRequests"`
+		// length of the windows deliveries are counted in, default to 1m
+		Window time.Duration `yaml:"window"`
+		// time the circuit stays open before a trial delivery probes the receiver, default to 30s
+		OpenInterval time.Duration `yaml:"openInterval"`
+	}
+
+	// DeliveryLimits caps the deliveries of a subscriber, they can be changed at runtime via the admin server.
+	// Throttled deliveries hold back consuming instead of dropping messages
+	DeliveryLimits struct {
+		// max notifications delivered per second, or requests per second with webhook batching. Default to 0 which means unlimited
+		RPS float64 `yaml:"rps"`
+		// max notifications(or batch requests) delivered at once after being idle, default to rps rounded up
+		Burst int `yaml:"burst"`
+		// max deliveries in progress, including their retries, or requests in progress with webhook batching.
+		// Default to 0 which means unlimited
+		MaxInFlight int `yaml:"maxInFlight"`
 	}
 
 	Webhook struct {
 		// Callback REST URL. See README for callback request format.
+		// The query and user info are redacted from /config and logs, use urlSecret if the path carries a token
 		URL url.URL `yaml:"url"`
-		// interval for retry when not receiving 200 from callback
-		RetryInterval time.Duration `yaml:"retryInterval"`
-		// max number of retries on error(not receiving 200)
-		MaxRetries    int  `yaml:"maxRetries"`
+		// the full callback URL as a secret, e.g. "https://hooks.slack.com/services/T000/B000/XXXX". Replaces url
+		URLSecret *Secret `yaml:"urlSecret"`
+		// RetryPolicy defines how failed callback requests are retried
+		RetryPolicy `yaml:",inline"`
+		// HTTP status codes that are retried, default to 429, 500, 502, 503 and 504. The Retry-After header of 429 and 503 is honored
+		// up to the remaining expirationInterval.
+		// Other status codes (e.g. 400, 404, 410) are permanent failures that go to consumerGroupDlqTopic without retrying.
+		// Any 2xx is a success
+		RetryableStatusCodes []int `yaml:"retryableStatusCodes"`
+		// classes of transport errors that are retried: "timeout", "connection", "dns", "tls".
+		// Default to "timeout", "connection" and "dns"
+		RetryableTransportErrors []string `yaml:"retryableTransportErrors"`
 		// context timeout of callback requests
-		CallbackRequestTimeout time.Duration  `yaml:"callbackRequestTimeout"`
+		CallbackRequestTimeout time.Duration `yaml:"callbackRequestTimeout"`
+		// follow 3xx redirects of the receiver. Default to false, which treats redirects as a config error
+		// and sends the notification to consumerGroupDlqTopic without retrying
+		FollowRedirects bool `yaml:"followRedirects"`
+		// Signing defines the secrets for signing callback requests
+		Signing Signing `yaml:"signing"`
+		// Go text/template rendering the request body from the Notification, see README for the template functions.
+		// Empty means the Notification as JSON
+		BodyTemplate string `yaml:"bodyTemplate"`
+		// header name -> Go text/template rendering the header value from the Notification.
+		// A "Content-Type" entry replaces the default "application/json".
+		// The values are redacted from /config, use headerSecrets for credentials
+		HeaderTemplates map[string]string `yaml:"headerTemplates"`
+		// header name -> secret header value, e.g. an Authorization header. Also sent with batch requests
+		HeaderSecrets map[string]Secret `yaml:"headerSecrets"`
+		// Batch defines sending multiple notifications per request, see README for the request and response format
+		Batch WebhookBatch `yaml:"batch"`
+	}
+
+	// WebhookBatch defines how notifications are batched into JSON arrays.
+	// Notifications are acked only after the receiver accepted them
+	WebhookBatch struct {
+		// max notifications per request, default to 0 which means no batching.
+		// consumer.concurrency is raised to at least this, as every notification in a batch takes a worker
+		MaxSize int `yaml:"maxSize"`
+		// max time to wait for filling a batch, default to 100ms
+		MaxWait time.Duration `yaml:"maxWait"`
+	}
+
+	// KafkaDelivery publishes notifications as JSON to a Kafka topic, keyed by workflowID
+	KafkaDelivery struct {
+		// topic to publish to, must be defined in kafka.topics
+		Topic string `yaml:"topic"`
+		// RetryPolicy defines how failed publishes are retried
+		RetryPolicy `yaml:",inline"`
+	}
+
+	// RetryPolicy defines an exponential backoff retry policy
+	RetryPolicy struct {
+		// initial interval for retry when not receiving 2xx from callback, default to 1s
+		RetryInterval time.Duration `yaml:"retryInterval"`
+		// max number of retries on error(not receiving 2xx), default to 0 which means retrying until expirationInterval.
+		// After running out of retries the notification is published to consumerGroupDlqTopic
+		MaxRetries int `yaml:"maxRetries"`
+		// upper bound of the interval between retries, default to 10s
+		MaxRetryInterval time.Duration `yaml:"maxRetryInterval"`
+		// multiplier of the interval after each retry, default to 2.0
+		BackoffCoefficient float64 `yaml:"backoffCoefficient"`
+		// max total time spent on retrying a notification, default to 1m
+		ExpirationInterval time.Duration `yaml:"expirationInterval"`
+		// coefficient in [0, 1] for randomizing retry intervals, e.g. 0.2 means +/-20%. Default to 0.2
+		Jitter *float64 `yaml:"jitter"`
+	}
+
+	// Signing defines the secrets of HMAC request signatures, see package common/signature
+	Signing struct {
+		// requests are signed with every secret, so that a new secret can be added before the old one is removed
+		Secrets []Secret `yaml:"secrets"`
+	}
+
+	// Secret is a secret value, set exactly one of the fields
+	Secret struct {
+		// inline secret value
+		Value string `yaml:"value"`
+		// name of the environment variable containing the secret
+		Env string `yaml:"env"`
+		// path of the file containing the secret, trailing whitespace is trimmed
+		File string `yaml:"file"`
+	}
+
+	// Receiver is the config of the built-in test receiver
+	Receiver struct {
+		// address to listen on, default to ":8801"
+		ListenAddress string `yaml:"listenAddress"`
+		// if secrets are configured, requests without a valid signature are rejected
+		Signing Signing `yaml:"signing"`
+		// max age of accepted signatures, default to 5m
+		SignatureTolerance time.Duration `yaml:"signatureTolerance"`
 	}
 
 	Filter struct {
-		// filtering based on domains -- notifications of which domain can be sent. Empty means selecting all
-		SelectedDomains []string  `yaml:"selectedDomains"`
+		// filtering based on domain names -- notifications of which domain can be sent. Empty means selecting all.
+		// Requires service.domainResolver to be configured. Notifications of domains still unknown after refreshing the
+		// domain mapping are filtered out, and counted by the unknown-domains metric.
+		SelectedDomains []string `yaml:"selectedDomains"`
+		// boolean expression evaluated against each notification, only matching notifications are sent. Empty means selecting all.
+		// e.g. op == "RecordClosed" && SearchAttributes.CloseStatus != 0 && WorkflowType.startsWith("payments.")
+		Expression string `yaml:"expression"`
 	}
 )
 
-// String converts the config object into a string
+// String converts the config object into a string, with secrets redacted
 func (c *Config) String() string {
-	out, _ := json.MarshalIndent(c, "", "    ")
+	out, _ := json.MarshalIndent(c.Redacted(), "", "    ")
 	return string(out)
-}
\ No newline at end of file
+}
+
+// Redacted returns a copy of the config that is safe to expose.
+// Inline secrets of webhooks and the receiver are redacted when marshaling to JSON, see Secret.
+// The query values and user info of webhook URLs are redacted too, as receivers often authenticate with them,
+// and so are the values of webhook header templates.
+func (c *Config) Redacted() *Config {
+	out := *c
+	if out.Kafka.SASL.Password != "" {
+		out.Kafka.SASL.Password = redacted
+	}
+	out.Service.Subscribers = make([]Subscriber, len(c.Service.Subscribers))
+	for i, subscriber := range c.Service.Subscribers {
+		webhook := &subscriber.Delivery.Webhook
+		webhook.URL = RedactURL(webhook.URL)
+		if webhook.HeaderTemplates != nil {
+			headerTemplates := make(map[string]string, len(webhook.HeaderTemplates))
+			for name := range webhook.HeaderTemplates {
+				headerTemplates[name] = redacted
+			}
+			webhook.HeaderTemplates = headerTemplates
+		}
+		out.Service.Subscribers[i] = subscriber
+	}
+	return &out
+}
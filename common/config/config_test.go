@@ -0,0 +1,129 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package config
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactedWebhookURL(t *testing.T) {
+	cfg := &Config{}
+	cfg.Service.Subscribers = []Subscriber{{Name: "test"}}
+	webhook := &cfg.Service.Subscribers[0].Delivery.Webhook
+	webhook.URL = url.URL{
+		Scheme:   "https",
+		User:     url.UserPassword("user", "password"),
+		Host:     "receiver.example.com",
+		Path:     "/notify",
+		RawQuery: "token=s3cr3t&channel=alerts",
+	}
+
+	out := cfg.String()
+	for _, leaked := range []string{"s3cr3t", "alerts", "password"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("expected %q to be redacted from %v", leaked, out)
+		}
+	}
+	if !strings.Contains(out, "receiver.example.com") || !strings.Contains(out, "/notify") {
+		t.Errorf("expected the host and path to be kept in %v", out)
+	}
+	if webhook.URL.RawQuery != "token=s3cr3t&channel=alerts" || webhook.URL.User.String() != "user:password" {
+		t.Errorf("expected the original config to be unchanged, got %v", webhook.URL.String())
+	}
+	if redacted := webhook.RedactedURL(); redacted != "https://%2A%2A%2A%2A%2A%2A@receiver.example.com/notify?channel=%2A%2A%2A%2A%2A%2A&token=%2A%2A%2A%2A%2A%2A" {
+		t.Errorf("unexpected redacted URL %v", redacted)
+	}
+}
+
+func TestWebhookURLSecret(t *testing.T) {
+	webhook := &Webhook{URLSecret: &Secret{Value: "https://hooks.example.com/services/T000/B000/XXXX"}}
+	u, err := webhook.LoadURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.String() != "https://hooks.example.com/services/T000/B000/XXXX" {
+		t.Errorf("unexpected URL %v", u)
+	}
+	if redacted := webhook.RedactedURL(); strings.Contains(redacted, "XXXX") {
+		t.Errorf("expected the path to be redacted, got %v", redacted)
+	}
+
+	cfg := &Config{}
+	cfg.Service.Subscribers = []Subscriber{{Name: "test"}}
+	cfg.Service.Subscribers[0].Delivery.Webhook = *webhook
+	if out := cfg.String(); strings.Contains(out, "XXXX") {
+		t.Errorf("expected the URL secret to be redacted from %v", out)
+	}
+
+	invalid := []*Webhook{
+		{},
+		{URL: url.URL{Host: "receiver.example.com"}, URLSecret: &Secret{Value: "https://hooks.example.com"}},
+		{URLSecret: &Secret{Value: "/services/T000"}},
+		{URLSecret: &Secret{}},
+	}
+	for _, webhook := range invalid {
+		if _, err := webhook.LoadURL(); err == nil {
+			t.Errorf("expected an error for %+v", webhook)
+		}
+	}
+}
+
+func TestWebhookHeaders(t *testing.T) {
+	cfg := &Config{}
+	cfg.Service.Subscribers = []Subscriber{{Name: "test"}}
+	webhook := &cfg.Service.Subscribers[0].Delivery.Webhook
+	webhook.HeaderTemplates = map[string]string{"X-Api-Key": "k3y-{{.WorkflowID}}"}
+	webhook.HeaderSecrets = map[string]Secret{"authorization": {Value: "Bearer t0ken"}}
+
+	out := cfg.String()
+	for _, leaked := range []string{"k3y", "t0ken"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("expected %q to be redacted from %v", leaked, out)
+		}
+	}
+	if webhook.HeaderTemplates["X-Api-Key"] != "k3y-{{.WorkflowID}}" {
+		t.Errorf("expected the original config to be unchanged, got %v", webhook.HeaderTemplates)
+	}
+
+	header, err := webhook.LoadHeaderSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := header.Get("Authorization"); value != "Bearer t0ken" {
+		t.Errorf("unexpected Authorization header %q", value)
+	}
+
+	invalid := []*Webhook{
+		{HeaderSecrets: map[string]Secret{"Authorization": {}}},
+		{HeaderSecrets: map[string]Secret{"Authorization": {Value: "Bearer t0ken\r\nX-Injected: 1"}}},
+		{
+			HeaderTemplates: map[string]string{"authorization": "{{.WorkflowID}}"},
+			HeaderSecrets:   map[string]Secret{"Authorization": {Value: "Bearer t0ken"}},
+		},
+	}
+	for _, webhook := range invalid {
+		if _, err := webhook.LoadHeaderSecrets(); err == nil {
+			t.Errorf("expected an error for %+v", webhook)
+		}
+	}
+}
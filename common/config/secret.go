@@ -0,0 +1,158 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const redacted = "******"
+
+// Load returns the secret value
+func (s Secret) Load() ([]byte, error) {
+	var value string
+	switch {
+	case s.Value != "" && s.Env == "" && s.File == "":
+		value = s.Value
+	case s.Value == "" && s.Env != "" && s.File == "":
+		value = os.Getenv(s.Env)
+		if value == "" {
+			return nil, fmt.Errorf("secret environment variable %v is empty", s.Env)
+		}
+	case s.Value == "" && s.Env == "" && s.File != "":
+		content, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret file: %v", err)
+		}
+		value = strings.TrimRight(string(content), " \t\r\n")
+		if value == "" {
+			return nil, fmt.Errorf("secret file %v is empty", s.File)
+		}
+	default:
+		return nil, fmt.Errorf("exactly one of value, env and file must be set for a secret")
+	}
+	return []byte(value), nil
+}
+
+// MarshalJSON redacts inline secret values, so that the config can be logged
+func (s Secret) MarshalJSON() ([]byte, error) {
+	type secret Secret
+	out := secret(s)
+	if out.Value != "" {
+		out.Value = redacted
+	}
+	return json.Marshal(out)
+}
+
+// LoadSecrets returns the values of all the secrets
+func (s *Signing) LoadSecrets() ([][]byte, error) {
+	secrets := make([][]byte, 0, len(s.Secrets))
+	for i, secret := range s.Secrets {
+		value, err := secret.Load()
+		if err != nil {
+			return nil, fmt.Errorf("secrets[%v]: %v", i, err)
+		}
+		secrets = append(secrets, value)
+	}
+	return secrets, nil
+}
+
+// LoadHeaderSecrets returns the headers of headerSecrets
+func (w *Webhook) LoadHeaderSecrets() (http.Header, error) {
+	header := make(http.Header, len(w.HeaderSecrets))
+	for name, secret := range w.HeaderSecrets {
+		for templateName := range w.HeaderTemplates {
+			if http.CanonicalHeaderKey(templateName) == http.CanonicalHeaderKey(name) {
+				return nil, fmt.Errorf("header %v can't be in both headerTemplates and headerSecrets", name)
+			}
+		}
+		value, err := secret.Load()
+		if err != nil {
+			return nil, fmt.Errorf("headerSecrets.%v: %v", name, err)
+		}
+		if strings.ContainsAny(string(value), "\r\n") {
+			return nil, fmt.Errorf("headerSecrets.%v must not contain line breaks", name)
+		}
+		header.Set(name, string(value))
+	}
+	return header, nil
+}
+
+// LoadURL returns the callback URL, from urlSecret if it's set
+func (w *Webhook) LoadURL() (*url.URL, error) {
+	if w.URLSecret == nil {
+		if w.URL.Host == "" {
+			return nil, fmt.Errorf("url.host is required")
+		}
+		u := w.URL
+		return &u, nil
+	}
+	if w.URL != (url.URL{}) {
+		return nil, fmt.Errorf("url and urlSecret can't be both set")
+	}
+	value, err := w.URLSecret.Load()
+	if err != nil {
+		return nil, fmt.Errorf("urlSecret: %v", err)
+	}
+	u, err := url.Parse(string(value))
+	if err != nil {
+		// the parse error would contain the secret
+		return nil, fmt.Errorf("urlSecret is not a valid URL")
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("urlSecret must be an absolute URL")
+	}
+	return u, nil
+}
+
+// RedactedURL returns the callback URL for logging, only the scheme and host are kept if it's from urlSecret
+func (w *Webhook) RedactedURL() string {
+	if w.URLSecret == nil {
+		u := RedactURL(w.URL)
+		return u.String()
+	}
+	u, err := w.LoadURL()
+	if err != nil {
+		return redacted
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/" + redacted}).String()
+}
+
+// RedactURL replaces the query values and user info of the URL
+func RedactURL(u url.URL) url.URL {
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query[key] = []string{redacted}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package signature signs webhook requests of cadence-notification, and verifies them on the receiver side.
+//
+// The signature header looks like
+//
+//	Cadence-Notification-Signature: t=1649305386,v1=5257a869...,v1=a1c0b7d3...
+//
+// where t is the unix timestamp of signing, and each v1 is the hex encoded HMAC-SHA256 of "<t>.<body>"
+// with one of the secrets. Multiple v1 entries are sent while a secret is being rotated,
+// a request is authentic if any of them matches any secret known to the receiver.
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Header is the HTTP header carrying the signature
+	Header = "Cadence-Notification-Signature"
+	// DefaultTolerance is the default max age of a signature accepted by Verify
+	DefaultTolerance = 5 * time.Minute
+
+	timestampKey = "t"
+	schemeV1     = "v1"
+)
+
+var (
+	// ErrMissingHeader is returned when the request has no signature header
+	ErrMissingHeader = errors.New("missing signature header")
+	// ErrInvalidHeader is returned when the signature header can't be parsed
+	ErrInvalidHeader = errors.New("invalid signature header")
+	// ErrTimestampExpired is returned when the signature is older than the tolerance, or too far in the future
+	ErrTimestampExpired = errors.New("signature timestamp is out of tolerance")
+	// ErrNoMatchingSignature is returned when none of the signatures matches any of the secrets
+	ErrNoMatchingSignature = errors.New("no matching signature")
+)
+
+// Sign returns the signature header value for the body, with one signature per secret
+func Sign(body []byte, timestamp time.Time, secrets [][]byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	parts := make([]string, 0, len(secrets)+1)
+	parts = append(parts, timestampKey+"="+t)
+	for _, secret := range secrets {
+		parts = append(parts, schemeV1+"="+hex.EncodeToString(computeMAC(t, body, secret)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Verify checks the signature header value against the body.
+// A zero tolerance means DefaultTolerance, a negative tolerance disables the timestamp check.
+func Verify(header string, body []byte, secrets [][]byte, tolerance time.Duration, now time.Time) error {
+	if header == "" {
+		return ErrMissingHeader
+	}
+
+	var t string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidHeader
+		}
+		switch kv[0] {
+		case timestampKey:
+			t = kv[1]
+		case schemeV1:
+			sig, err := hex.DecodeString(kv[1])
+			if err != nil {
+				return ErrInvalidHeader
+			}
+			signatures = append(signatures, sig)
+		default:
+			// ignore unknown schemes for forward compatibility
+		}
+	}
+	unixSeconds, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return ErrInvalidHeader
+	}
+
+	if tolerance == 0 {
+		tolerance = DefaultTolerance
+	}
+	if tolerance > 0 {
+		age := now.Sub(time.Unix(unixSeconds, 0))
+		if age > tolerance || age < -tolerance {
+			return ErrTimestampExpired
+		}
+	}
+
+	for _, secret := range secrets {
+		expected := computeMAC(t, body, secret)
+		for _, sig := range signatures {
+			if hmac.Equal(expected, sig) {
+				return nil
+			}
+		}
+	}
+	return ErrNoMatchingSignature
+}
+
+// VerifyRequest reads the body of the request and verifies its signature header.
+// The body is returned so that the caller can still process it.
+func VerifyRequest(r *http.Request, secrets [][]byte, tolerance time.Duration) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+	return body, Verify(r.Header.Get(Header), body, secrets, tolerance, time.Now())
+}
+
+func computeMAC(timestamp string, body []byte, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
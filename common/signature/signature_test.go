@@ -0,0 +1,156 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package signature
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testBody      = []byte(`{"WorkflowID":"order-123"}`)
+	testTimestamp = time.Unix(1649305386, 0)
+	oldSecret     = []byte("old-secret")
+	newSecret     = []byte("new-secret")
+)
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	header := Sign(testBody, testTimestamp, [][]byte{newSecret})
+	if !strings.HasPrefix(header, "t=1649305386,v1=") {
+		t.Fatalf("unexpected header %v", header)
+	}
+	if err := Verify(header, testBody, [][]byte{newSecret}, 0, testTimestamp); err != nil {
+		t.Errorf("expected the signature to verify, got %v", err)
+	}
+	// the same input always gives the same signature
+	if again := Sign(testBody, testTimestamp, [][]byte{newSecret}); again != header {
+		t.Errorf("expected a deterministic signature, got %v and %v", header, again)
+	}
+
+	tampered := append([]byte{}, testBody...)
+	tampered[len(tampered)-2] = 'X'
+	if err := Verify(header, tampered, [][]byte{newSecret}, 0, testTimestamp); err != ErrNoMatchingSignature {
+		t.Errorf("expected ErrNoMatchingSignature for a tampered body, got %v", err)
+	}
+	if err := Verify(header, testBody, [][]byte{[]byte("wrong-secret")}, 0, testTimestamp); err != ErrNoMatchingSignature {
+		t.Errorf("expected ErrNoMatchingSignature for a wrong secret, got %v", err)
+	}
+	// the timestamp is signed too
+	forged := strings.Replace(header, "t=1649305386", "t=1649305387", 1)
+	if err := Verify(forged, testBody, [][]byte{newSecret}, 0, testTimestamp); err != ErrNoMatchingSignature {
+		t.Errorf("expected ErrNoMatchingSignature for a changed timestamp, got %v", err)
+	}
+}
+
+func TestSecretRotation(t *testing.T) {
+	header := Sign(testBody, testTimestamp, [][]byte{newSecret, oldSecret})
+	if n := strings.Count(header, "v1="); n != 2 {
+		t.Fatalf("expected 2 v1 entries, got %v in %v", n, header)
+	}
+
+	tests := map[string][][]byte{
+		"receiver knows the old secret only":      {oldSecret},
+		"receiver knows the new secret only":      {newSecret},
+		"receiver knows both secrets":             {oldSecret, newSecret},
+		"receiver knows an unrelated secret, too": {[]byte("unrelated"), newSecret},
+	}
+	for name, secrets := range tests {
+		if err := Verify(header, testBody, secrets, 0, testTimestamp); err != nil {
+			t.Errorf("%v: expected the signature to verify, got %v", name, err)
+		}
+	}
+
+	// a sender that already dropped the old secret is rejected by a receiver that only knows the old one
+	header = Sign(testBody, testTimestamp, [][]byte{newSecret})
+	if err := Verify(header, testBody, [][]byte{oldSecret}, 0, testTimestamp); err != ErrNoMatchingSignature {
+		t.Errorf("expected ErrNoMatchingSignature, got %v", err)
+	}
+}
+
+func TestTimestampTolerance(t *testing.T) {
+	header := Sign(testBody, testTimestamp, [][]byte{newSecret})
+	tests := []struct {
+		name      string
+		now       time.Time
+		tolerance time.Duration
+		want      error
+	}{
+		{"just signed", testTimestamp, 0, nil},
+		{"within default tolerance", testTimestamp.Add(DefaultTolerance), 0, nil},
+		{"older than default tolerance", testTimestamp.Add(DefaultTolerance + time.Second), 0, ErrTimestampExpired},
+		{"too far in the future", testTimestamp.Add(-DefaultTolerance - time.Second), 0, ErrTimestampExpired},
+		{"within custom tolerance", testTimestamp.Add(time.Hour), 2 * time.Hour, nil},
+		{"older than custom tolerance", testTimestamp.Add(time.Minute), 30 * time.Second, ErrTimestampExpired},
+		{"check disabled", testTimestamp.Add(24 * time.Hour), -1, nil},
+	}
+	for _, test := range tests {
+		if err := Verify(header, testBody, [][]byte{newSecret}, test.tolerance, test.now); err != test.want {
+			t.Errorf("%v: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestMalformedHeaders(t *testing.T) {
+	valid := Sign(testBody, testTimestamp, [][]byte{newSecret})
+	signature := strings.SplitN(valid, ",", 2)[1]
+	tests := []struct {
+		header string
+		want   error
+	}{
+		{"", ErrMissingHeader},
+		{"garbage", ErrInvalidHeader},
+		{"t=1649305386,", ErrInvalidHeader},
+		{signature, ErrInvalidHeader},
+		{"t=not-a-number," + signature, ErrInvalidHeader},
+		{"t=1649305386,v1=not-hex", ErrInvalidHeader},
+		{"t=1649305386", ErrNoMatchingSignature},
+		{"t=1649305386,v0=" + strings.TrimPrefix(signature, "v1="), ErrNoMatchingSignature},
+		{"t=1649305386,v1=", ErrNoMatchingSignature},
+		// unknown schemes are ignored and spaces are trimmed
+		{"t=1649305386, v2=abc, " + signature, nil},
+	}
+	for _, test := range tests {
+		if err := Verify(test.header, testBody, [][]byte{newSecret}, 0, testTimestamp); err != test.want {
+			t.Errorf("Verify(%q): got %v, want %v", test.header, err, test.want)
+		}
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(testBody))
+	r.Header.Set(Header, Sign(testBody, time.Now(), [][]byte{newSecret}))
+	body, err := VerifyRequest(r, [][]byte{newSecret}, 0)
+	if err != nil {
+		t.Errorf("expected the request to verify, got %v", err)
+	}
+	if !bytes.Equal(body, testBody) {
+		t.Errorf("expected the body to be returned, got %s", body)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(testBody))
+	if _, err := VerifyRequest(r, [][]byte{newSecret}, 0); err != ErrMissingHeader {
+		t.Errorf("expected ErrMissingHeader, got %v", err)
+	}
+}
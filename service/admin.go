@@ -0,0 +1,282 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+const (
+	subscriberStateInitialized = "initialized"
+	subscriberStateStarting    = "starting"
+	subscriberStateStarted     = "started"
+	subscriberStateStopped     = "stopped"
+
+	adminShutdownTimeout = 5 * time.Second
+)
+
+type (
+	// adminServer serves health checks and the state of the subscribers over HTTP
+	adminServer struct {
+		config *config.Config
+		server *http.Server
+		logger log.Logger
+		// nil if the state is not persisted
+		stateStore *pauseStateStore
+
+		// protects notifiers, allAdded and config which change on config reloads
+		lock      sync.RWMutex
+		notifiers []*notifier
+		// set once all the notifiers are created
+		allAdded bool
+	}
+
+	// subscriberStatus is the state of a subscriber reported by the admin server
+	subscriberStatus struct {
+		Name     string         `json:"name"`
+		State    string         `json:"state"`
+		InFlight int64          `json:"inFlight"`
+		Limits   deliveryLimits `json:"limits"`
+		// state of the circuit breaker, empty if it's disabled
+		CircuitBreaker  string     `json:"circuitBreaker,omitempty"`
+		LastSuccessTime *time.Time `json:"lastSuccessTime,omitempty"`
+		LastError       string     `json:"lastError,omitempty"`
+		LastErrorTime   *time.Time `json:"lastErrorTime,omitempty"`
+	}
+
+	readiness struct {
+		Ready       bool            `json:"ready"`
+		Subscribers map[string]bool `json:"subscribers"`
+	}
+)
+
+func newAdminServer(cfg *config.Config, stateStore *pauseStateStore, logger log.Logger) *adminServer {
+	s := &adminServer{
+		config:     cfg,
+		logger:     logger.WithTags(tag.Name("AdminServer")),
+		stateStore: stateStore,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/ready", s.handleReady)
+	mux.HandleFunc("/subscribers", s.handleSubscribers)
+	mux.HandleFunc("/subscribers/", s.handleSubscriberAction)
+	mux.HandleFunc("/config", s.handleConfig)
+	s.server = &http.Server{
+		Addr:    cfg.Service.Admin.ListenAddress,
+		Handler: mux,
+	}
+	return s
+}
+
+func (s *adminServer) Start() {
+	s.logger.Info("admin server starting", tag.Address(s.server.Addr))
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Fatal("admin server failed", tag.Error(err))
+		}
+	}()
+}
+
+func (s *adminServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.logger.Warn("failed to stop admin server", tag.Error(err))
+	}
+}
+
+// addNotifier makes a notifier visible to the admin endpoints
+func (s *adminServer) addNotifier(n *notifier) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.notifiers = append(s.notifiers, n)
+}
+
+// setAllAdded marks that all the configured notifiers are added, before that the service is not ready
+func (s *adminServer) setAllAdded() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.allAdded = true
+}
+
+// removeNotifier hides a notifier that is stopped because of a config reload
+func (s *adminServer) removeNotifier(n *notifier) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, candidate := range s.notifiers {
+		if candidate == n {
+			s.notifiers = append(s.notifiers[:i:i], s.notifiers[i+1:]...)
+			return
+		}
+	}
+}
+
+// setConfig updates the config served on /config after a reload
+func (s *adminServer) setConfig(cfg *config.Config) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.config = cfg
+}
+
+func (s *adminServer) getNotifiers() ([]*notifier, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*notifier(nil), s.notifiers...), s.allAdded
+}
+
+func (s *adminServer) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *adminServer) handleReady(w http.ResponseWriter, _ *http.Request) {
+	notifiers, allAdded := s.getNotifiers()
+	result := &readiness{
+		Ready:       allAdded,
+		Subscribers: make(map[string]bool),
+	}
+	for _, n := range notifiers {
+		// paused subscribers are still ready, pausing is intentional
+		started := atomic.LoadInt32(&n.isConsumerStarted) == 1 && atomic.LoadInt32(&n.isStopped) == 0
+		result.Subscribers[n.subscriberConfig.Name] = started
+		result.Ready = result.Ready && started
+	}
+
+	statusCode := http.StatusOK
+	if !result.Ready {
+		statusCode = http.StatusServiceUnavailable
+	}
+	s.writeJSON(w, statusCode, result)
+}
+
+func (s *adminServer) handleSubscribers(w http.ResponseWriter, _ *http.Request) {
+	notifiers, _ := s.getNotifiers()
+	statuses := make([]*subscriberStatus, 0, len(notifiers))
+	for _, n := range notifiers {
+		statuses = append(statuses, n.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	s.writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleSubscriberAction handles POST /subscribers/<name>/pause, /resume, /drain and /limits
+func (s *adminServer) handleSubscriberAction(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/subscribers/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	name, action := parts[0], parts[1]
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var n *notifier
+	notifiers, _ := s.getNotifiers()
+	for _, candidate := range notifiers {
+		if candidate.subscriberConfig.Name == name {
+			n = candidate
+		}
+	}
+	if n == nil {
+		http.Error(w, "unknown subscriber "+name, http.StatusNotFound)
+		return
+	}
+
+	if action == "limits" {
+		s.handleLimits(w, r, n)
+		return
+	}
+
+	var persistedState string
+	switch action {
+	case "pause":
+		n.pause()
+		persistedState = subscriberStatePaused
+	case "resume":
+		n.resume()
+	case "drain":
+		n.drain()
+		persistedState = subscriberStateDrained
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if s.stateStore != nil {
+		if err := s.stateStore.set(name, persistedState); err != nil {
+			s.logger.Error("failed to persist subscriber state", tag.Error(err))
+			http.Error(w, "state changed but failed to persist: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	s.writeJSON(w, http.StatusOK, n.status())
+}
+
+// handleLimits changes the delivery limits of a subscriber with a JSON body like {"rps": 10, "burst": 20, "maxInFlight": 5},
+// omitted fields are unchanged. The limits fall back to the config after a restart or a reload of the subscriber
+func (s *adminServer) handleLimits(w http.ResponseWriter, r *http.Request, n *notifier) {
+	var update deliveryLimitsUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "invalid limits: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	limits, err := n.limiter.update(&update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n.logger.Info(fmt.Sprintf("delivery limits changed to %+v", limits))
+	s.writeJSON(w, http.StatusOK, n.status())
+}
+
+func (s *adminServer) handleConfig(w http.ResponseWriter, _ *http.Request) {
+	s.lock.RLock()
+	cfg := s.config
+	s.lock.RUnlock()
+	s.writeJSON(w, http.StatusOK, cfg.Redacted())
+}
+
+func (s *adminServer) writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	body, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		s.logger.Error("failed to encode admin response", tag.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+	"github.com/uber/cadence/common/log"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+
+	defaultCircuitMinRequests  = 10
+	defaultCircuitWindow       = time.Minute
+	defaultCircuitOpenInterval = 30 * time.Second
+)
+
+// values of the circuitBreakerState gauge
+var circuitStateValues = map[string]float64{
+	circuitClosed:   0,
+	circuitHalfOpen: 1,
+	circuitOpen:     2,
+}
+
+type (
+	// circuitBreaker holds back deliveries while the receiver keeps failing.
+	// When open, the first delivery after openInterval is let through as a trial, which closes the circuit on success
+	circuitBreaker struct {
+		failureRatio float64
+		minRequests  int
+		window       time.Duration
+		openInterval time.Duration
+
+		lock        sync.Mutex
+		state       string
+		windowStart time.Time
+		requests    int
+		failures    int
+		openUntil   time.Time
+		// closed and replaced on state changes
+		changed chan struct{}
+
+		logger      log.Logger
+		metricScope tally.Scope
+	}
+
+	// attemptBreakingSink is implemented by sinks retrying with retryDelivery, which consults the circuit breaker
+	// of the context before every attempt and records every result, instead of the notifier once per delivery
+	attemptBreakingSink interface {
+		breaksPerAttempt() bool
+	}
+
+	circuitBreakerKey struct{}
+)
+
+func validateCircuitBreaker(cfg *config.CircuitBreaker) error {
+	if cfg.FailureRatio < 0 || cfg.FailureRatio > 1 {
+		return fmt.Errorf("delivery.circuitBreaker.failureRatio must be within [0, 1], got %v", cfg.FailureRatio)
+	}
+	if cfg.MinRequests < 0 || cfg.Window < 0 || cfg.OpenInterval < 0 {
+		return fmt.Errorf("delivery.circuitBreaker.minRequests, window and openInterval must not be negative")
+	}
+	return nil
+}
+
+// newCircuitBreaker returns nil if the circuit breaker is disabled
+func newCircuitBreaker(cfg *config.CircuitBreaker, logger log.Logger, metricScope tally.Scope) *circuitBreaker {
+	if cfg.FailureRatio <= 0 {
+		return nil
+	}
+	b := &circuitBreaker{
+		failureRatio: cfg.FailureRatio,
+		minRequests:  cfg.MinRequests,
+		window:       cfg.Window,
+		openInterval: cfg.OpenInterval,
+		state:        circuitClosed,
+		windowStart:  time.Now(),
+		changed:      make(chan struct{}),
+		logger:       logger,
+		metricScope:  metricScope,
+	}
+	if b.minRequests <= 0 {
+		b.minRequests = defaultCircuitMinRequests
+	}
+	if b.window <= 0 {
+		b.window = defaultCircuitWindow
+	}
+	if b.openInterval <= 0 {
+		b.openInterval = defaultCircuitOpenInterval
+	}
+	metricScope.Gauge(circuitBreakerState).Update(circuitStateValues[circuitClosed])
+	return b
+}
+
+// allow waits until a delivery may be made. The delivery is a trial if the circuit is half-open,
+// its result must be passed to record either way.
+func (b *circuitBreaker) allow(ctx context.Context) (trial bool, err error) {
+	for {
+		b.lock.Lock()
+		var wait <-chan time.Time
+		switch b.state {
+		case circuitClosed:
+			b.lock.Unlock()
+			return false, nil
+		case circuitOpen:
+			if untilTrial := time.Until(b.openUntil); untilTrial > 0 {
+				wait = time.After(untilTrial)
+			} else {
+				// other deliveries wait for the result of the trial
+				b.setState(circuitHalfOpen)
+				b.lock.Unlock()
+				return true, nil
+			}
+		}
+		changed := b.changed
+		b.lock.Unlock()
+
+		select {
+		case <-wait:
+		case <-changed:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// record counts the result of a delivery allowed by allow
+func (b *circuitBreaker) record(trial bool, success bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if trial {
+		if success {
+			b.resetWindow()
+			b.setState(circuitClosed)
+		} else {
+			b.open()
+		}
+		return
+	}
+	// deliveries started before the circuit opened don't count
+	if b.state != circuitClosed {
+		return
+	}
+	if time.Since(b.windowStart) >= b.window {
+		b.resetWindow()
+	}
+	b.requests++
+	if !success {
+		b.failures++
+	}
+	if b.requests >= b.minRequests && float64(b.failures) >= b.failureRatio*float64(b.requests) {
+		b.logger.Warn(fmt.Sprintf("%v of %v deliveries failed, opening the circuit breaker", b.failures, b.requests))
+		b.open()
+	}
+}
+
+// recordResult records the result of a delivery or an attempt allowed by allow. Results of canceled deliveries don't count,
+// and neither do permanent rejections by the receiver, which prove it's up though, so they close the circuit on a trial.
+func (b *circuitBreaker) recordResult(ctx context.Context, trial bool, err error, rejected bool) {
+	switch {
+	case err == nil:
+		b.record(trial, true)
+	case ctx.Err() != nil:
+		b.cancelTrial(trial)
+	case rejected:
+		if trial {
+			b.record(trial, true)
+		}
+	default:
+		b.record(trial, false)
+	}
+}
+
+// cancelTrial lets the next delivery be the trial, if the trial was canceled before its result
+func (b *circuitBreaker) cancelTrial(trial bool) {
+	if !trial {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == circuitHalfOpen {
+		b.openUntil = time.Now()
+		b.setState(circuitOpen)
+	}
+}
+
+func (b *circuitBreaker) getState() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+func (b *circuitBreaker) open() {
+	b.openUntil = time.Now().Add(b.openInterval)
+	if b.state != circuitOpen {
+		b.metricScope.Counter(circuitBreakerOpened).Inc(1)
+	}
+	b.setState(circuitOpen)
+}
+
+func (b *circuitBreaker) resetWindow() {
+	b.windowStart = time.Now()
+	b.requests = 0
+	b.failures = 0
+}
+
+func (b *circuitBreaker) setState(state string) {
+	if b.state != state {
+		b.logger.Info(fmt.Sprintf("circuit breaker state changed from %v to %v", b.state, state))
+	}
+	b.state = state
+	b.metricScope.Gauge(circuitBreakerState).Update(circuitStateValues[state])
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// withCircuitBreaker passes the circuit breaker to retryDelivery, for sinks implementing attemptBreakingSink
+func withCircuitBreaker(ctx context.Context, b *circuitBreaker) context.Context {
+	return context.WithValue(ctx, circuitBreakerKey{}, b)
+}
+
+// circuitBreakerFromContext returns nil if the delivery isn't subject to a circuit breaker
+func circuitBreakerFromContext(ctx context.Context) *circuitBreaker {
+	b, _ := ctx.Value(circuitBreakerKey{}).(*circuitBreaker)
+	return b
+}
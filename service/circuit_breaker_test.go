@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/uber-go/tally"
+	"github.com/uber/cadence/common/log/loggerimpl"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+func newTestCircuitBreaker() *circuitBreaker {
+	return newCircuitBreaker(&config.CircuitBreaker{FailureRatio: 0.5, MinRequests: 2, OpenInterval: time.Hour},
+		loggerimpl.NewNopLogger(), tally.NoopScope)
+}
+
+func newTestRetryPolicy(t *testing.T) *retryPolicy {
+	jitter := 0.0
+	policy, err := newRetryPolicy(&config.RetryPolicy{RetryInterval: time.Millisecond, MaxRetries: 5, Jitter: &jitter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestCircuitBreakerPerAttempt(t *testing.T) {
+	breaker := newTestCircuitBreaker()
+	classifier, err := newRetryClassifier(&config.Webhook{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(withCircuitBreaker(context.Background(), breaker), 100*time.Millisecond)
+	defer cancel()
+
+	attempts := 0
+	err = retryDelivery(ctx, newTestRetryPolicy(t), classifier.isRetryable, tally.NoopScope, func(int) error {
+		attempts++
+		return &statusCodeError{statusCode: http.StatusInternalServerError}
+	})
+	// the circuit opens after the first two attempts, holding back the retries of the same delivery
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts before the circuit opens, got %v", attempts)
+	}
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected the retry to wait for the open circuit, got %v", err)
+	}
+	if state := breaker.getState(); state != circuitOpen {
+		t.Errorf("expected the circuit to be open, got %v", state)
+	}
+}
+
+func TestCircuitBreakerIgnoresRejections(t *testing.T) {
+	breaker := newTestCircuitBreaker()
+	classifier, err := newRetryClassifier(&config.Webhook{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withCircuitBreaker(context.Background(), breaker)
+	for i := 0; i < 5; i++ {
+		err := retryDelivery(ctx, newTestRetryPolicy(t), classifier.isRetryable, tally.NoopScope, func(int) error {
+			return &statusCodeError{statusCode: http.StatusBadRequest}
+		})
+		if deliveryErr, ok := err.(*DeliveryError); !ok || deliveryErr.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected the rejection to be returned, got %v", err)
+		}
+	}
+	if state := breaker.getState(); state != circuitClosed {
+		t.Errorf("expected rejections to keep the circuit closed, got %v", state)
+	}
+}
+
+func TestCircuitBreakerTrial(t *testing.T) {
+	breaker := newTestCircuitBreaker()
+	breaker.record(false, false)
+	breaker.record(false, false)
+	if state := breaker.getState(); state != circuitOpen {
+		t.Fatalf("expected the circuit to be open, got %v", state)
+	}
+	breaker.lock.Lock()
+	breaker.openUntil = time.Now()
+	breaker.lock.Unlock()
+
+	// a canceled trial lets the next attempt be the trial
+	trial, err := breaker.allow(context.Background())
+	if err != nil || !trial {
+		t.Fatalf("expected a trial, got %v, %v", trial, err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	breaker.recordResult(canceled, trial, context.Canceled, false)
+	if state := breaker.getState(); state != circuitOpen {
+		t.Errorf("expected the circuit to be open after a canceled trial, got %v", state)
+	}
+
+	// a rejection proves the receiver is up
+	trial, err = breaker.allow(context.Background())
+	if err != nil || !trial {
+		t.Fatalf("expected a trial, got %v, %v", trial, err)
+	}
+	breaker.recordResult(context.Background(), trial, &statusCodeError{statusCode: http.StatusBadRequest}, true)
+	if state := breaker.getState(); state != circuitClosed {
+		t.Errorf("expected a rejected trial to close the circuit, got %v", state)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/uber/cadence/common"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+const (
+	formatNotification = "notification"
+	formatCloudEvents  = "cloudevents"
+
+	cloudEventsModeStructured = "structured"
+	cloudEventsModeBinary     = "binary"
+
+	cloudEventsSpecVersion = "1.0"
+	// content type of structured mode events
+	contentTypeCloudEventsJSON = "application/cloudevents+json"
+
+	// CloudEventTypeWorkflowStarted is the CloudEvents type of RecordStarted notifications
+	CloudEventTypeWorkflowStarted = "io.cadence.workflow.started"
+	// CloudEventTypeWorkflowClosed is the CloudEvents type of RecordClosed notifications
+	CloudEventTypeWorkflowClosed = "io.cadence.workflow.closed"
+	// CloudEventTypeSearchAttributesUpserted is the CloudEvents type of UpsertSearchAttributes notifications
+	CloudEventTypeSearchAttributesUpserted = "io.cadence.workflow.searchattributes.upserted"
+)
+
+type (
+	// cloudEventsEncoder wraps notifications in CloudEvents 1.0
+	cloudEventsEncoder struct {
+		binary  bool
+		cluster string
+	}
+
+	// cloudEvent is the JSON format of a CloudEvent carrying a notification
+	cloudEvent struct {
+		SpecVersion     string        `json:"specversion"`
+		ID              string        `json:"id"`
+		Source          string        `json:"source"`
+		Type            string        `json:"type"`
+		Subject         string        `json:"subject"`
+		Time            *time.Time    `json:"time,omitempty"`
+		DataContentType string        `json:"datacontenttype"`
+		Data            *Notification `json:"data"`
+	}
+
+	// encodedCloudEvent is a CloudEvent ready to be sent with a protocol binding
+	encodedCloudEvent struct {
+		body        []byte
+		contentType string
+		// attribute name -> value, only set in binary mode. The protocol binding adds the header prefix
+		attributes map[string]string
+	}
+)
+
+func validateFormat(subscriber *config.Subscriber) error {
+	switch subscriber.Format {
+	case "", formatNotification:
+		return nil
+	case formatCloudEvents:
+		switch subscriber.CloudEvents.Mode {
+		case "", cloudEventsModeStructured, cloudEventsModeBinary:
+			return nil
+		default:
+			return fmt.Errorf("unknown cloudEvents.mode %q, supported values: %v, %v",
+				subscriber.CloudEvents.Mode, cloudEventsModeStructured, cloudEventsModeBinary)
+		}
+	default:
+		return fmt.Errorf("unknown format %q, supported values: %v, %v", subscriber.Format, formatNotification, formatCloudEvents)
+	}
+}
+
+// newCloudEventsEncoder returns nil if the subscriber's format isn't cloudevents
+func newCloudEventsEncoder(subscriber *config.Subscriber) (*cloudEventsEncoder, error) {
+	if err := validateFormat(subscriber); err != nil {
+		return nil, err
+	}
+	if subscriber.Format != formatCloudEvents {
+		return nil, nil
+	}
+	return &cloudEventsEncoder{
+		binary:  subscriber.CloudEvents.Mode == cloudEventsModeBinary,
+		cluster: subscriber.CloudEvents.Cluster,
+	}, nil
+}
+
+func (e *cloudEventsEncoder) encode(notification *Notification) (*encodedCloudEvent, error) {
+	event, err := e.newCloudEvent(notification)
+	if err != nil {
+		return nil, err
+	}
+	if !e.binary {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		return &encodedCloudEvent{body: body, contentType: contentTypeCloudEventsJSON}, nil
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return nil, err
+	}
+	attributes := map[string]string{
+		"specversion": event.SpecVersion,
+		"id":          event.ID,
+		"source":      event.Source,
+		"type":        event.Type,
+		"subject":     event.Subject,
+	}
+	if event.Time != nil {
+		attributes["time"] = event.Time.UTC().Format(time.RFC3339Nano)
+	}
+	return &encodedCloudEvent{body: body, contentType: event.DataContentType, attributes: attributes}, nil
+}
+
+func (e *cloudEventsEncoder) newCloudEvent(notification *Notification) (*cloudEvent, error) {
+	domain := notification.DomainName
+	if domain == "" {
+		domain = notification.DomainID
+	}
+	source := "/cadence/domains/" + domain
+	if e.cluster != "" {
+		source = fmt.Sprintf("/cadence/%v/domains/%v", e.cluster, domain)
+	}
+
+	event := &cloudEvent{
+		SpecVersion: cloudEventsSpecVersion,
+		// the same for every delivery of a visibility event, which together with source makes it unique
+		ID:              notification.IdempotencyKey,
+		Source:          source,
+		Subject:         notification.WorkflowID + "/" + notification.RunID,
+		DataContentType: contentTypeJSON,
+		Data:            notification,
+	}
+	switch notification.VisibilityOperation {
+	case common.RecordStarted:
+		event.Type = CloudEventTypeWorkflowStarted
+		event.Time = notification.StartedTimestamp
+	case common.RecordClosed:
+		event.Type = CloudEventTypeWorkflowClosed
+		event.Time = notification.ClosedTimestamp
+	case common.UpsertSearchAttributes:
+		// upserts don't carry the time of the change
+		event.Type = CloudEventTypeSearchAttributesUpserted
+	default:
+		return nil, fmt.Errorf("no CloudEvents type for visibility operation %q", notification.VisibilityOperation)
+	}
+	return event, nil
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/uber/cadence/common"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+func newTestCloudEventsEncoder(t *testing.T, mode string, cluster string) *cloudEventsEncoder {
+	subscriber := &config.Subscriber{Format: formatCloudEvents}
+	subscriber.CloudEvents.Mode = mode
+	subscriber.CloudEvents.Cluster = cluster
+	encoder, err := newCloudEventsEncoder(subscriber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoder
+}
+
+func TestNewCloudEvent(t *testing.T) {
+	started := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+	closed := started.Add(time.Hour)
+	tests := []struct {
+		name         string
+		cluster      string
+		notification *Notification
+		expectedType string
+		expectedTime *time.Time
+		source       string
+	}{
+		{
+			name:         "started",
+			notification: &Notification{VisibilityOperation: common.RecordStarted, DomainName: "domain", StartedTimestamp: &started},
+			expectedType: CloudEventTypeWorkflowStarted,
+			expectedTime: &started,
+			source:       "/cadence/domains/domain",
+		},
+		{
+			name:    "closed",
+			cluster: "cluster0",
+			notification: &Notification{VisibilityOperation: common.RecordClosed, DomainName: "domain",
+				StartedTimestamp: &started, ClosedTimestamp: &closed},
+			expectedType: CloudEventTypeWorkflowClosed,
+			expectedTime: &closed,
+			source:       "/cadence/cluster0/domains/domain",
+		},
+		{
+			name:         "upserted without domain name",
+			notification: &Notification{VisibilityOperation: common.UpsertSearchAttributes, StartedTimestamp: &started},
+			expectedType: CloudEventTypeSearchAttributesUpserted,
+			source:       "/cadence/domains/domain-id",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.notification.DomainID = "domain-id"
+			test.notification.WorkflowID = "workflow-id"
+			test.notification.RunID = "run-id"
+			test.notification.IdempotencyKey = "key"
+			event, err := newTestCloudEventsEncoder(t, "", test.cluster).newCloudEvent(test.notification)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != test.expectedType {
+				t.Errorf("expected type %v, got %v", test.expectedType, event.Type)
+			}
+			if event.Source != test.source {
+				t.Errorf("expected source %v, got %v", test.source, event.Source)
+			}
+			if event.Subject != "workflow-id/run-id" {
+				t.Errorf("expected subject workflow-id/run-id, got %v", event.Subject)
+			}
+			if event.ID != "key" {
+				t.Errorf("expected the idempotency key as id, got %v", event.ID)
+			}
+			if (event.Time == nil) != (test.expectedTime == nil) || event.Time != nil && !event.Time.Equal(*test.expectedTime) {
+				t.Errorf("expected time %v, got %v", test.expectedTime, event.Time)
+			}
+		})
+	}
+
+	if _, err := newTestCloudEventsEncoder(t, "", "").newCloudEvent(&Notification{VisibilityOperation: "Unknown"}); err == nil {
+		t.Error("expected an error for an unknown visibility operation")
+	}
+}
+
+func TestCloudEventsEncode(t *testing.T) {
+	closed := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+	notification := &Notification{
+		IdempotencyKey:      "key",
+		VisibilityOperation: common.RecordClosed,
+		DomainName:          "domain",
+		WorkflowID:          "workflow-id",
+		RunID:               "run-id",
+		ClosedTimestamp:     &closed,
+	}
+
+	t.Run("structured", func(t *testing.T) {
+		encoded, err := newTestCloudEventsEncoder(t, cloudEventsModeStructured, "").encode(notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoded.contentType != contentTypeCloudEventsJSON {
+			t.Errorf("expected content type %v, got %v", contentTypeCloudEventsJSON, encoded.contentType)
+		}
+		if len(encoded.attributes) > 0 {
+			t.Errorf("expected no attributes in structured mode, got %v", encoded.attributes)
+		}
+		var event cloudEvent
+		if err := json.Unmarshal(encoded.body, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.SpecVersion != cloudEventsSpecVersion || event.ID != "key" || event.Type != CloudEventTypeWorkflowClosed ||
+			event.Source != "/cadence/domains/domain" || event.Subject != "workflow-id/run-id" || event.DataContentType != contentTypeJSON {
+			t.Errorf("unexpected event attributes %+v", event)
+		}
+		if event.Time == nil || !event.Time.Equal(closed) {
+			t.Errorf("expected time %v, got %v", closed, event.Time)
+		}
+		if event.Data == nil || event.Data.RunID != "run-id" {
+			t.Errorf("expected the notification as data, got %+v", event.Data)
+		}
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		encoded, err := newTestCloudEventsEncoder(t, cloudEventsModeBinary, "").encode(notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoded.contentType != contentTypeJSON {
+			t.Errorf("expected content type %v, got %v", contentTypeJSON, encoded.contentType)
+		}
+		expected := map[string]string{
+			"specversion": cloudEventsSpecVersion,
+			"id":          "key",
+			"source":      "/cadence/domains/domain",
+			"type":        CloudEventTypeWorkflowClosed,
+			"subject":     "workflow-id/run-id",
+			"time":        "2021-01-02T03:04:05.000000006Z",
+		}
+		for name, value := range expected {
+			if encoded.attributes[name] != value {
+				t.Errorf("expected attribute %v %v, got %v", name, value, encoded.attributes[name])
+			}
+		}
+		var data Notification
+		if err := json.Unmarshal(encoded.body, &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.RunID != "run-id" {
+			t.Errorf("expected the notification as body, got %+v", data)
+		}
+	})
+
+	t.Run("binary upsert", func(t *testing.T) {
+		upserted := *notification
+		upserted.VisibilityOperation = common.UpsertSearchAttributes
+		encoded, err := newTestCloudEventsEncoder(t, cloudEventsModeBinary, "").encode(&upserted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := encoded.attributes["time"]; ok {
+			t.Error("expected no time attribute for upserts")
+		}
+	})
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/cadence/common/cache"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+const (
+	defaultDedupMaxKeys = 100000
+	// interval of persisting the remembered keys, they're also persisted when the notifier stops
+	dedupSaveInterval = 10 * time.Second
+	// the state file is compacted when it has this many times more entries than maxKeys
+	dedupCompactionRatio = 2
+)
+
+type (
+	// dedupWindow remembers the idempotency keys of recently delivered notifications.
+	// The state file is an append-only log of JSON lines in the order of delivery, which is compacted to the
+	// remembered keys once it grows too large, so that saving only writes the keys delivered since the last save.
+	dedupWindow struct {
+		window    time.Duration
+		maxKeys   int
+		stateFile string
+		// key -> time of delivery
+		keys cache.Cache
+		// keys delivered since the last save, in the order of delivery
+		pending     []dedupStateEntry
+		pendingLock sync.Mutex
+		// number of entries in the state file, including forgotten and repeated keys
+		fileEntries int
+		// serializes saving the state file
+		saveLock sync.Mutex
+	}
+
+	dedupStateEntry struct {
+		Key         string    `json:"key"`
+		DeliveredAt time.Time `json:"deliveredAt"`
+	}
+)
+
+// newDedupWindow returns nil if deduplication is disabled
+func newDedupWindow(cfg *config.Dedup) *dedupWindow {
+	if cfg.Window <= 0 {
+		return nil
+	}
+	maxKeys := cfg.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = defaultDedupMaxKeys
+	}
+	return &dedupWindow{
+		window:    cfg.Window,
+		maxKeys:   maxKeys,
+		stateFile: cfg.StateFile,
+		// the cache evicts when the count reaches MaxCount
+		keys: cache.New(&cache.Options{TTL: cfg.Window, MaxCount: maxKeys + 1}),
+	}
+}
+
+func validateDedup(cfg *config.Dedup) error {
+	if cfg.Window < 0 {
+		return fmt.Errorf("dedup.window must not be negative")
+	}
+	if cfg.MaxKeys < 0 {
+		return fmt.Errorf("dedup.maxKeys must not be negative")
+	}
+	if cfg.StateFile != "" && cfg.Window == 0 {
+		return fmt.Errorf("dedup.stateFile requires dedup.window")
+	}
+	return nil
+}
+
+// seen returns true if the key was delivered within the window
+func (d *dedupWindow) seen(key string) bool {
+	deliveredAt, ok := d.keys.Get(key).(time.Time)
+	// the cache TTL counts from loading the state file, the delivery time is what matters
+	return ok && time.Since(deliveredAt) < d.window
+}
+
+func (d *dedupWindow) add(key string) {
+	deliveredAt := time.Now()
+	d.keys.Put(key, deliveredAt)
+	if d.stateFile != "" {
+		d.pendingLock.Lock()
+		d.pending = append(d.pending, dedupStateEntry{Key: key, DeliveredAt: deliveredAt})
+		d.pendingLock.Unlock()
+	}
+}
+
+// load restores the keys from the state file, if any
+func (d *dedupWindow) load() error {
+	if d.stateFile == "" {
+		return nil
+	}
+	f, err := os.Open(d.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read dedup state file: %v", err)
+	}
+	defer f.Close()
+
+	var partialLine error
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if partialLine != nil {
+			return partialLine
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry dedupStateEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// only the last line can be partially written by a crash
+			partialLine = fmt.Errorf("invalid dedup state file %v at line %v: %v", d.stateFile, line, err)
+			continue
+		}
+		d.fileEntries++
+		// entries are in the order of delivery, so the cache forgets the earliest delivered first
+		if time.Since(entry.DeliveredAt) < d.window {
+			d.keys.Put(entry.Key, entry.DeliveredAt)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read dedup state file: %v", err)
+	}
+	if partialLine != nil {
+		// rewritten, so that the next entries aren't appended to the partial line
+		d.saveLock.Lock()
+		defer d.saveLock.Unlock()
+		return d.compact()
+	}
+	return nil
+}
+
+// save appends the keys delivered since the last save to the state file, if configured
+func (d *dedupWindow) save() error {
+	if d.stateFile == "" {
+		return nil
+	}
+	d.saveLock.Lock()
+	defer d.saveLock.Unlock()
+
+	d.pendingLock.Lock()
+	entries := d.pending
+	d.pending = nil
+	d.pendingLock.Unlock()
+	if d.fileEntries+len(entries) > dedupCompactionRatio*d.maxKeys {
+		// the cache has the pending keys too
+		return d.compact()
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if err := d.append(entries); err != nil {
+		// saved again next time, a key appended twice is loaded once
+		d.pendingLock.Lock()
+		d.pending = append(entries, d.pending...)
+		d.pendingLock.Unlock()
+		return err
+	}
+	d.fileEntries += len(entries)
+	return nil
+}
+
+func (d *dedupWindow) append(entries []dedupStateEntry) error {
+	if err := os.MkdirAll(filepath.Dir(d.stateFile), 0755); err != nil {
+		return fmt.Errorf("failed to create dedup state file directory: %v", err)
+	}
+	f, err := os.OpenFile(d.stateFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write dedup state file: %v", err)
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for i := range entries {
+		if err := encoder.Encode(&entries[i]); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write dedup state file: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write dedup state file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write dedup state file: %v", err)
+	}
+	return nil
+}
+
+// compact rewrites the state file with the remembered keys in the order of delivery, the caller must hold saveLock
+func (d *dedupWindow) compact() error {
+	// the iterator holds the cache lock, so the entries are only collected here
+	entries := make([]dedupStateEntry, 0, d.keys.Size())
+	it := d.keys.Iterator()
+	for it.HasNext() {
+		entry := it.Next()
+		if deliveredAt := entry.Value().(time.Time); time.Since(deliveredAt) < d.window {
+			entries = append(entries, dedupStateEntry{Key: entry.Key().(string), DeliveredAt: deliveredAt})
+		}
+	}
+	it.Close()
+	// the iterator is in the order of access, which differs from delivery when duplicates are looked up
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].DeliveredAt.Before(entries[j].DeliveredAt)
+	})
+
+	if err := os.MkdirAll(filepath.Dir(d.stateFile), 0755); err != nil {
+		return fmt.Errorf("failed to create dedup state file directory: %v", err)
+	}
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	for i := range entries {
+		if err := encoder.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	// write to a temp file and rename, so that a crash never leaves a partial file
+	tmpPath := d.stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write dedup state file: %v", err)
+	}
+	if err := os.Rename(tmpPath, d.stateFile); err != nil {
+		return fmt.Errorf("failed to write dedup state file: %v", err)
+	}
+	d.fileEntries = len(entries)
+	return nil
+}
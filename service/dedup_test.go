@@ -0,0 +1,132 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+func newTestDedupWindow(t *testing.T, stateFile string, maxKeys int) *dedupWindow {
+	d := newDedupWindow(&config.Dedup{Window: time.Hour, MaxKeys: maxKeys, StateFile: stateFile})
+	if err := d.load(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func readDedupStateFile(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry dedupStateEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, entry.Key)
+	}
+	return keys
+}
+
+func TestDedupWindowSaveAndLoad(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state", "dedup.jsonl")
+	d := newTestDedupWindow(t, stateFile, 10)
+	d.add("a")
+	d.add("b")
+	if err := d.save(); err != nil {
+		t.Fatal(err)
+	}
+	d.add("c")
+	if err := d.save(); err != nil {
+		t.Fatal(err)
+	}
+	// saving only appends the keys delivered since the last save
+	if keys := readDedupStateFile(t, stateFile); fmt.Sprint(keys) != "[a b c]" {
+		t.Errorf("expected the state file to have [a b c], got %v", keys)
+	}
+
+	restored := newTestDedupWindow(t, stateFile, 10)
+	for _, key := range []string{"a", "b", "c"} {
+		if !restored.seen(key) {
+			t.Errorf("expected %v to be restored", key)
+		}
+	}
+	if restored.seen("d") {
+		t.Error("expected d to be unknown")
+	}
+}
+
+func TestDedupWindowCompaction(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "dedup.jsonl")
+	d := newTestDedupWindow(t, stateFile, 3)
+	for i := 0; i < 7; i++ {
+		if i == 6 {
+			// looking up a duplicate makes it the most recently used, the file keeps the order of delivery
+			d.seen("4")
+		}
+		d.add(fmt.Sprint(i))
+		if err := d.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keys := readDedupStateFile(t, stateFile); fmt.Sprint(keys) != "[4 5 6]" {
+		t.Errorf("expected the state file to be compacted to [4 5 6], got %v", keys)
+	}
+}
+
+func TestDedupWindowPartialLine(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "dedup.jsonl")
+	content := fmt.Sprintf("{\"key\":\"a\",\"deliveredAt\":%q}\n{\"key\":\"b\",\"deli", time.Now().Format(time.RFC3339Nano))
+	if err := ioutil.WriteFile(stateFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDedupWindow(t, stateFile, 10)
+	if !d.seen("a") {
+		t.Error("expected a to be restored")
+	}
+	d.add("c")
+	if err := d.save(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := readDedupStateFile(t, stateFile); fmt.Sprint(keys) != "[a c]" {
+		t.Errorf("expected the partial line to be dropped, got %v", keys)
+	}
+
+	if err := ioutil.WriteFile(stateFile, []byte("{\n"+content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := newDedupWindow(&config.Dedup{Window: time.Hour, StateFile: stateFile}).load(); err == nil {
+		t.Error("expected an invalid line before the last one to fail loading")
+	}
+}
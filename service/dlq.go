@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	cconfig "github.com/uber/cadence/common/config"
+)
+
+// Headers of the messages published to a subscriber's consumerGroupDlqTopic.
+// The message value is the original visibility message payload.
+const (
+	// DLQHeaderFailureReason is the error of the last delivery attempt
+	DLQHeaderFailureReason = "cadence-notification-failure-reason"
+	// DLQHeaderAttempts is the number of delivery attempts
+	DLQHeaderAttempts = "cadence-notification-attempts"
+	// DLQHeaderLastStatusCode is the HTTP status code of the last delivery attempt, 0 if there was no response
+	DLQHeaderLastStatusCode = "cadence-notification-last-status-code"
+	// DLQHeaderSubscriber is the name of the subscriber
+	DLQHeaderSubscriber = "cadence-notification-subscriber"
+	// DLQHeaderFailedAt is the time of giving up the delivery, in RFC3339 format
+	DLQHeaderFailedAt = "cadence-notification-failed-at"
+	// DLQHeaderSourcePartition is the partition of the original message in the visibility topic
+	DLQHeaderSourcePartition = "cadence-notification-source-partition"
+	// DLQHeaderSourceOffset is the offset of the original message in the visibility topic
+	DLQHeaderSourceOffset = "cadence-notification-source-offset"
+)
+
+type (
+	// dlqPublisher publishes messages that failed delivery to the subscriber's DLQ topic
+	dlqPublisher struct {
+		topic    string
+		producer sarama.SyncProducer
+	}
+
+	// dlqEntry describes a message that failed delivery
+	dlqEntry struct {
+		key             string
+		payload         []byte
+		subscriber      string
+		reason          string
+		attempts        int
+		lastStatusCode  int
+		sourcePartition int32
+		sourceOffset    int64
+	}
+)
+
+func newDLQPublisher(kafkaConfig *cconfig.KafkaConfig, topic string) (*dlqPublisher, error) {
+	producer, err := newSyncProducer(kafkaConfig, topic)
+	if err != nil {
+		return nil, err
+	}
+	return &dlqPublisher{
+		topic:    topic,
+		producer: producer,
+	}, nil
+}
+
+func (d *dlqPublisher) publish(entry *dlqEntry) error {
+	headers := map[string]string{
+		DLQHeaderFailureReason:   entry.reason,
+		DLQHeaderAttempts:        strconv.Itoa(entry.attempts),
+		DLQHeaderLastStatusCode:  strconv.Itoa(entry.lastStatusCode),
+		DLQHeaderSubscriber:      entry.subscriber,
+		DLQHeaderFailedAt:        time.Now().UTC().Format(time.RFC3339),
+		DLQHeaderSourcePartition: strconv.Itoa(int(entry.sourcePartition)),
+		DLQHeaderSourceOffset:    strconv.FormatInt(entry.sourceOffset, 10),
+	}
+	msg := &sarama.ProducerMessage{
+		Topic: d.topic,
+		Key:   sarama.StringEncoder(entry.key),
+		Value: sarama.ByteEncoder(entry.payload),
+	}
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	_, _, err := d.producer.SendMessage(msg)
+	return err
+}
+
+func (d *dlqPublisher) close() error {
+	return d.producer.Close()
+}
@@ -0,0 +1,429 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/uber-go/tally"
+	"github.com/uber/cadence/.gen/go/indexer"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+	"golang.org/x/time/rate"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+type (
+	// DLQOptions selects the entries of a subscriber's consumerGroupDlqTopic. Empty fields match all entries.
+	DLQOptions struct {
+		// Subscriber is the name of the subscriber
+		Subscriber string
+		// WorkflowID matches the workflowID of the notification
+		WorkflowID string
+		// StatusCode matches the last status code of the failed delivery, 0 matches any
+		StatusCode int
+		// FailedAfter and FailedBefore are the time range of giving up the delivery, used if not zero
+		FailedAfter  time.Time
+		FailedBefore time.Time
+		// Entries are the IDs of the selected entries, as "partition:offset" of the DLQ topic. Empty means all matching entries
+		Entries []string
+		// LedgerFile records the successfully redriven entries, they're skipped by later redrives. Empty means no ledger
+		LedgerFile string
+		// RateLimit is the max redrives per second, 0 means unlimited
+		RateLimit float64
+		// DryRun writes the notifications that would be redriven to Output instead of delivering them
+		DryRun bool
+		Output io.Writer
+		// Progress receives the failures of redrives, can be nil
+		Progress io.Writer
+	}
+
+	// DLQEntry is a message of a subscriber's consumerGroupDlqTopic
+	DLQEntry struct {
+		ID              string        `json:"id"`
+		Partition       int32         `json:"partition"`
+		Offset          int64         `json:"offset"`
+		Timestamp       time.Time     `json:"timestamp"`
+		FailureReason   string        `json:"failureReason"`
+		Attempts        int           `json:"attempts"`
+		LastStatusCode  int           `json:"lastStatusCode"`
+		FailedAt        time.Time     `json:"failedAt"`
+		SourcePartition int32         `json:"sourcePartition"`
+		SourceOffset    int64         `json:"sourceOffset"`
+		Redriven        bool          `json:"redriven"`
+		Notification    *Notification `json:"notification,omitempty"`
+		// DecodeError is set if the payload couldn't be decoded into a notification
+		DecodeError string `json:"decodeError,omitempty"`
+	}
+
+	// RedriveResult counts the redriven entries
+	RedriveResult struct {
+		Succeeded int64
+		Failed    int64
+		// already redriven according to the ledger
+		Skipped int64
+	}
+
+	// redriveLedger is an append-only file of JSON lines, one per successfully redriven entry
+	redriveLedger struct {
+		path    string
+		entries map[string]bool
+	}
+
+	redriveLedgerEntry struct {
+		Topic      string    `json:"topic"`
+		ID         string    `json:"id"`
+		RedrivenAt time.Time `json:"redrivenAt"`
+	}
+
+	// dlqReader reads and decodes the DLQ topic of a subscriber
+	dlqReader struct {
+		topic              string
+		notifier           *notifier
+		ledger             *redriveLedger
+		options            *DLQOptions
+		stopDomainResolver func()
+	}
+)
+
+// ListDLQ calls visit with each entry of the subscriber's DLQ topic matching the options, in order per partition
+func ListDLQ(ctx context.Context, cfg *config.Config, options *DLQOptions, logger log.Logger, visit func(*DLQEntry) error) error {
+	r, err := newDLQReader(cfg, options, nil, logger, tally.NoopScope)
+	if err != nil {
+		return err
+	}
+	defer r.stopDomainResolver()
+	return r.read(ctx, &cfg.Kafka, visit)
+}
+
+// RedriveDLQ delivers the entries of the subscriber's DLQ topic matching the options through the subscriber's sink,
+// within the subscriber's delivery.limits and circuitBreaker.
+// Entries delivered successfully are recorded in the ledger and skipped by later redrives.
+// Entries failing again stay in the DLQ topic and are not published to it again.
+func RedriveDLQ(
+	ctx context.Context,
+	cfg *config.Config,
+	options *DLQOptions,
+	logger log.Logger,
+	metricScope tally.Scope,
+) (*RedriveResult, error) {
+	subscriberConfig, err := getSubscriberConfig(cfg, options.Subscriber)
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.WithTags(tag.Name("Redrive-" + subscriberConfig.Name))
+	metricScope = metricScope.Tagged(map[string]string{subscriberTag: subscriberConfig.Name})
+	var sink Sink = &stdoutSink{output: options.Output}
+	if !options.DryRun {
+		sink, err = newSink(&SinkParams{
+			Subscriber:  subscriberConfig,
+			KafkaConfig: &cfg.Kafka,
+			Logger:      logger,
+			MetricScope: metricScope,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	r, err := newDLQReader(cfg, options, sink, logger, metricScope)
+	if err != nil {
+		return nil, err
+	}
+	defer r.stopDomainResolver()
+	if err := sink.Start(); err != nil {
+		return nil, err
+	}
+	defer sink.Stop()
+
+	var limiter *rate.Limiter
+	if options.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(options.RateLimit), 1)
+	}
+	progress := options.Progress
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+	result := &RedriveResult{}
+	err = r.read(ctx, &cfg.Kafka, func(entry *DLQEntry) error {
+		if entry.Redriven {
+			result.Skipped++
+			return nil
+		}
+		if entry.Notification == nil {
+			fmt.Fprintf(progress, "entry %v: skipped, %v\n", entry.ID, entry.DecodeError)
+			result.Failed++
+			return nil
+		}
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		deliveryCtx := withDeliveryInfo(ctx, &DeliveryInfo{
+			Subscriber:      options.Subscriber,
+			SourcePartition: entry.SourcePartition,
+			SourceOffset:    entry.SourceOffset,
+		})
+		// same delivery limits and circuit breaker as the subscriber's notifier
+		if err := r.notifier.deliver(deliveryCtx, entry.Notification); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(progress, "entry %v: failed, %v\n", entry.ID, err)
+			result.Failed++
+			return nil
+		}
+		result.Succeeded++
+		if options.DryRun {
+			return nil
+		}
+		return r.ledger.add(r.topic, entry.ID)
+	})
+	return result, err
+}
+
+func getSubscriberConfig(cfg *config.Config, name string) (*config.Subscriber, error) {
+	for i := range cfg.Service.Subscribers {
+		if cfg.Service.Subscribers[i].Name == name {
+			return &cfg.Service.Subscribers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown subscriber %q", name)
+}
+
+func newDLQReader(cfg *config.Config, options *DLQOptions, sink Sink, logger log.Logger, metricScope tally.Scope) (*dlqReader, error) {
+	subscriberConfig, err := getSubscriberConfig(cfg, options.Subscriber)
+	if err != nil {
+		return nil, err
+	}
+	topic := subscriberConfig.Consumer.ConsumerGroupDlqTopic
+	if topic == "" {
+		return nil, fmt.Errorf("subscriber %v has no consumerGroupDlqTopic", subscriberConfig.Name)
+	}
+	for _, id := range options.Entries {
+		if _, _, err := parseDLQEntryID(id); err != nil {
+			return nil, err
+		}
+	}
+	ledger, err := loadRedriveLedger(options.LedgerFile)
+	if err != nil {
+		return nil, err
+	}
+	domainResolver, stopDomainResolver, err := startDomainResolver(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	// the notifier decodes the payload into a notification, and delivers it within the limits and the circuit breaker
+	p, err := newNotifierWithSink(&cfg.Kafka, subscriberConfig, domainResolver, sink, logger, metricScope)
+	if err != nil {
+		stopDomainResolver()
+		return nil, err
+	}
+	return &dlqReader{
+		topic:              topic,
+		notifier:           p,
+		ledger:             ledger,
+		options:            options,
+		stopDomainResolver: stopDomainResolver,
+	}, nil
+}
+
+func (r *dlqReader) read(ctx context.Context, kafkaConfig *cconfig.KafkaConfig, visit func(*DLQEntry) error) error {
+	client, err := newReplayClient(kafkaConfig, r.topic)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ranges, err := getReplayRanges(client, r.topic, &ReplayOptions{StartOffset: -1, EndOffset: -1})
+	if err != nil {
+		return err
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	for _, pr := range ranges {
+		err := readPartitionRange(ctx, consumer, r.topic, pr, func(msg *sarama.ConsumerMessage) error {
+			entry := r.decode(msg)
+			if !r.matches(entry) {
+				return nil
+			}
+			return visit(entry)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *dlqReader) decode(msg *sarama.ConsumerMessage) *DLQEntry {
+	entry := &DLQEntry{
+		ID:        formatDLQEntryID(msg.Partition, msg.Offset),
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		// entries published before the failed-at header existed fall back to the message timestamp
+		FailedAt: msg.Timestamp,
+	}
+	for _, header := range msg.Headers {
+		value := string(header.Value)
+		switch string(header.Key) {
+		case DLQHeaderFailureReason:
+			entry.FailureReason = value
+		case DLQHeaderAttempts:
+			entry.Attempts, _ = strconv.Atoi(value)
+		case DLQHeaderLastStatusCode:
+			entry.LastStatusCode, _ = strconv.Atoi(value)
+		case DLQHeaderFailedAt:
+			if t, err := time.Parse(time.RFC3339, value); err == nil {
+				entry.FailedAt = t
+			}
+		case DLQHeaderSourcePartition:
+			partition, _ := strconv.ParseInt(value, 10, 32)
+			entry.SourcePartition = int32(partition)
+		case DLQHeaderSourceOffset:
+			entry.SourceOffset, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	entry.Redriven = r.ledger.contains(r.topic, entry.ID)
+
+	decodedMsg, err := r.notifier.deserialize(msg.Value)
+	if err != nil {
+		entry.DecodeError = fmt.Sprintf("failed to deserialize payload: %v", err)
+		return entry
+	}
+	if decodedMsg.GetMessageType() != indexer.MessageTypeIndex {
+		entry.DecodeError = fmt.Sprintf("unexpected message type %v", decodedMsg.GetMessageType())
+		return entry
+	}
+	// same ID as the original delivery, so that receivers can deduplicate
+	id := fmt.Sprintf("%v-%v", entry.SourcePartition, entry.SourceOffset)
+	if entry.Notification, err = r.notifier.generateNotification(decodedMsg, id); err != nil {
+		entry.DecodeError = fmt.Sprintf("failed to generate notification: %v", err)
+	}
+	return entry
+}
+
+func (r *dlqReader) matches(entry *DLQEntry) bool {
+	o := r.options
+	if len(o.Entries) > 0 {
+		selected := false
+		for _, id := range o.Entries {
+			selected = selected || id == entry.ID
+		}
+		if !selected {
+			return false
+		}
+	}
+	if o.WorkflowID != "" && (entry.Notification == nil || entry.Notification.WorkflowID != o.WorkflowID) {
+		return false
+	}
+	if o.StatusCode != 0 && entry.LastStatusCode != o.StatusCode {
+		return false
+	}
+	if !o.FailedAfter.IsZero() && entry.FailedAt.Before(o.FailedAfter) {
+		return false
+	}
+	if !o.FailedBefore.IsZero() && !entry.FailedAt.Before(o.FailedBefore) {
+		return false
+	}
+	return true
+}
+
+func formatDLQEntryID(partition int32, offset int64) string {
+	return fmt.Sprintf("%v:%v", partition, offset)
+}
+
+func parseDLQEntryID(id string) (int32, int64, error) {
+	var partition int32
+	var offset int64
+	if n, err := fmt.Sscanf(id, "%d:%d", &partition, &offset); err != nil || n != 2 || formatDLQEntryID(partition, offset) != id {
+		return 0, 0, fmt.Errorf("invalid DLQ entry %q, expecting partition:offset", id)
+	}
+	return partition, offset, nil
+}
+
+func loadRedriveLedger(path string) (*redriveLedger, error) {
+	l := &redriveLedger{path: path, entries: make(map[string]bool)}
+	if path == "" {
+		return l, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry redriveLedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid redrive ledger %v at line %v: %v", path, line, err)
+		}
+		l.entries[entry.Topic+"/"+entry.ID] = true
+	}
+	return l, scanner.Err()
+}
+
+func (l *redriveLedger) contains(topic, id string) bool {
+	return l.entries[topic+"/"+id]
+}
+
+// add appends the entry to the ledger file right away, so that an interrupted redrive doesn't lose it
+func (l *redriveLedger) add(topic, id string) error {
+	l.entries[topic+"/"+id] = true
+	if l.path == "" {
+		return nil
+	}
+	line, err := json.Marshal(&redriveLedgerEntry{Topic: topic, ID: id, RedrivenAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/uber-go/tally"
+	"github.com/uber/cadence/.gen/go/indexer"
+	"github.com/uber/cadence/common/codec"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log/loggerimpl"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+func TestParseDLQEntryID(t *testing.T) {
+	tests := []struct {
+		id        string
+		partition int32
+		offset    int64
+		valid     bool
+	}{
+		{id: "0:0", valid: true},
+		{id: "3:12345", partition: 3, offset: 12345, valid: true},
+		{id: ""},
+		{id: "3"},
+		{id: "3:"},
+		{id: ":12"},
+		{id: "3-12"},
+		{id: "3:12:1"},
+		{id: "3:12abc"},
+		{id: " 3:12"},
+		{id: "03:12"},
+		{id: "a:b"},
+		{id: "3:99999999999999999999"},
+	}
+	for _, test := range tests {
+		partition, offset, err := parseDLQEntryID(test.id)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("expected %q to be invalid, got %v:%v", test.id, partition, offset)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected %q to be valid, got %v", test.id, err)
+		} else if partition != test.partition || offset != test.offset {
+			t.Errorf("expected %q to be %v:%v, got %v:%v", test.id, test.partition, test.offset, partition, offset)
+		}
+	}
+}
+
+func TestDLQReaderMatches(t *testing.T) {
+	failedAt := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	entry := &DLQEntry{
+		ID:             "1:10",
+		LastStatusCode: 503,
+		FailedAt:       failedAt,
+		Notification:   &Notification{WorkflowID: "workflow-id"},
+	}
+	undecoded := &DLQEntry{ID: "1:11", FailedAt: failedAt, DecodeError: "failed to deserialize payload"}
+	tests := []struct {
+		name      string
+		options   DLQOptions
+		matches   bool
+		undecoded bool
+	}{
+		{name: "no filter", matches: true, undecoded: true},
+		{name: "selected entry", options: DLQOptions{Entries: []string{"0:10", "1:10"}}, matches: true},
+		{name: "other entry", options: DLQOptions{Entries: []string{"0:10"}}},
+		{name: "workflowID", options: DLQOptions{WorkflowID: "workflow-id"}, matches: true},
+		{name: "other workflowID", options: DLQOptions{WorkflowID: "other"}},
+		{name: "status code", options: DLQOptions{StatusCode: 503}, matches: true},
+		{name: "other status code", options: DLQOptions{StatusCode: 400}},
+		{name: "failed after, inclusive", options: DLQOptions{FailedAfter: failedAt}, matches: true, undecoded: true},
+		{name: "failed after, later", options: DLQOptions{FailedAfter: failedAt.Add(time.Second)}},
+		{name: "failed before, exclusive", options: DLQOptions{FailedBefore: failedAt}},
+		{name: "failed before, later", options: DLQOptions{FailedBefore: failedAt.Add(time.Second)}, matches: true, undecoded: true},
+		{
+			name:    "all filters",
+			options: DLQOptions{Entries: []string{"1:10"}, WorkflowID: "workflow-id", StatusCode: 503, FailedAfter: failedAt.Add(-time.Hour), FailedBefore: failedAt.Add(time.Hour)},
+			matches: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &dlqReader{options: &test.options}
+			if matches := r.matches(entry); matches != test.matches {
+				t.Errorf("expected matches %v, got %v", test.matches, matches)
+			}
+			if matches := r.matches(undecoded); matches != test.undecoded {
+				t.Errorf("expected the undecoded entry to match %v, got %v", test.undecoded, matches)
+			}
+		})
+	}
+}
+
+func TestRedriveLedgerAcrossRuns(t *testing.T) {
+	ledgerFile := filepath.Join(t.TempDir(), "ledger.jsonl")
+	payload, err := codec.NewThriftRWEncoder().Encode(&indexer.Message{
+		MessageType: indexer.MessageTypeIndex.Ptr(),
+		DomainID:    stringPtr("domain-id"),
+		WorkflowID:  stringPtr("workflow-id"),
+		RunID:       stringPtr("run-id"),
+		Version:     int64Ptr(1),
+		Fields:      map[string]*indexer.Field{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newReader := func(topic string) *dlqReader {
+		ledger, err := loadRedriveLedger(ledgerFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subscriber := &config.Subscriber{Name: "test"}
+		p, err := newNotifierWithSink(&cconfig.KafkaConfig{}, subscriber, nil, &fakeSink{}, loggerimpl.NewNopLogger(), tally.NoopScope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &dlqReader{topic: topic, notifier: p, ledger: ledger, options: &DLQOptions{LedgerFile: ledgerFile}}
+	}
+	redriven := &sarama.ConsumerMessage{Partition: 1, Offset: 10, Value: payload}
+	pending := &sarama.ConsumerMessage{Partition: 1, Offset: 11, Value: payload}
+
+	first := newReader("dlq")
+	for _, msg := range []*sarama.ConsumerMessage{redriven, pending} {
+		if entry := first.decode(msg); entry.Redriven || entry.Notification == nil {
+			t.Fatalf("expected a decoded entry not redriven yet, got %+v", entry)
+		}
+	}
+	if err := first.ledger.add(first.topic, formatDLQEntryID(redriven.Partition, redriven.Offset)); err != nil {
+		t.Fatal(err)
+	}
+
+	second := newReader("dlq")
+	if entry := second.decode(redriven); !entry.Redriven {
+		t.Error("expected the entry redriven by the previous run to be skipped")
+	}
+	if entry := second.decode(pending); entry.Redriven {
+		t.Error("expected the entry not redriven by the previous run not to be skipped")
+	}
+	// the ledger is per DLQ topic
+	if entry := newReader("other-dlq").decode(redriven); entry.Redriven {
+		t.Error("expected the entry of another topic not to be skipped")
+	}
+}
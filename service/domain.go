@@ -0,0 +1,225 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultDomainRefreshInterval = time.Minute
+	// min interval between refreshes triggered by looking up unknown domains
+	domainMissRefreshInterval = 10 * time.Second
+)
+
+type (
+	// DomainResolver translates between domain IDs and domain names
+	DomainResolver interface {
+		// GetDomainName returns the name of the domain, false if the domain ID is unknown
+		GetDomainName(domainID string) (string, bool)
+		// GetDomainID returns the ID of the domain, false if the domain name is unknown
+		GetDomainID(domainName string) (string, bool)
+	}
+
+	// DomainSource loads the complete domain name -> domain ID mapping
+	DomainSource interface {
+		LoadDomains() (map[string]string, error)
+	}
+
+	// cachedDomainResolver keeps the mapping of a DomainSource in memory and reloads it periodically
+	cachedDomainResolver struct {
+		source          DomainSource
+		refreshInterval time.Duration
+		logger          log.Logger
+
+		sync.RWMutex
+		nameToID    map[string]string
+		idToName    map[string]string
+		lastRefresh time.Time
+		// serializes the refreshes triggered by unknown domains
+		missLock sync.Mutex
+
+		status     int32
+		shutdownWG sync.WaitGroup
+		shutdownCh chan struct{}
+	}
+
+	// staticDomainSource reads the mapping from a YAML file
+	staticDomainSource struct {
+		path string
+	}
+)
+
+var _ DomainResolver = (*cachedDomainResolver)(nil)
+
+// newCachedDomainResolver builds a resolver which reloads domains from the source every refreshInterval
+func newCachedDomainResolver(source DomainSource, refreshInterval time.Duration, logger log.Logger) *cachedDomainResolver {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultDomainRefreshInterval
+	}
+	return &cachedDomainResolver{
+		source:          source,
+		refreshInterval: refreshInterval,
+		logger:          logger.WithTags(tag.Name("DomainResolver")),
+		nameToID:        map[string]string{},
+		idToName:        map[string]string{},
+		status:          common.DaemonStatusInitialized,
+		shutdownCh:      make(chan struct{}),
+	}
+}
+
+// newStaticDomainSource builds a DomainSource backed by a YAML file of domain name -> domain ID entries
+func newStaticDomainSource(path string) DomainSource {
+	return &staticDomainSource{path: path}
+}
+
+// Start loads the mapping once and then keeps refreshing it in background
+func (r *cachedDomainResolver) Start() error {
+	if !atomic.CompareAndSwapInt32(&r.status, common.DaemonStatusInitialized, common.DaemonStatusStarted) {
+		return nil
+	}
+	if err := r.refresh(); err != nil {
+		return err
+	}
+
+	r.shutdownWG.Add(1)
+	go r.refreshLoop()
+	return nil
+}
+
+// Stop stops the background refreshing
+func (r *cachedDomainResolver) Stop() {
+	if !atomic.CompareAndSwapInt32(&r.status, common.DaemonStatusStarted, common.DaemonStatusStopped) {
+		return
+	}
+	close(r.shutdownCh)
+	r.shutdownWG.Wait()
+}
+
+// GetDomainName refreshes the mapping if the domain is unknown, so that a new domain is picked up without waiting for the next refresh
+func (r *cachedDomainResolver) GetDomainName(domainID string) (string, bool) {
+	if name, ok := r.lookupName(domainID); ok {
+		return name, true
+	}
+	r.refreshOnMiss()
+	return r.lookupName(domainID)
+}
+
+// GetDomainID refreshes the mapping if the domain is unknown, same as GetDomainName
+func (r *cachedDomainResolver) GetDomainID(domainName string) (string, bool) {
+	if id, ok := r.lookupID(domainName); ok {
+		return id, true
+	}
+	r.refreshOnMiss()
+	return r.lookupID(domainName)
+}
+
+func (r *cachedDomainResolver) lookupName(domainID string) (string, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	name, ok := r.idToName[domainID]
+	return name, ok
+}
+
+func (r *cachedDomainResolver) lookupID(domainName string) (string, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	id, ok := r.nameToID[domainName]
+	return id, ok
+}
+
+// refreshOnMiss refreshes the mapping at most once per domainMissRefreshInterval.
+// Concurrent lookups of unknown domains wait for the same refresh.
+func (r *cachedDomainResolver) refreshOnMiss() {
+	r.missLock.Lock()
+	defer r.missLock.Unlock()
+
+	r.RLock()
+	lastRefresh := r.lastRefresh
+	r.RUnlock()
+	if time.Since(lastRefresh) < domainMissRefreshInterval {
+		return
+	}
+	if err := r.refresh(); err != nil {
+		r.logger.Warn("failed to refresh domains for an unknown domain", tag.Error(err))
+	}
+}
+
+func (r *cachedDomainResolver) refreshLoop() {
+	defer r.shutdownWG.Done()
+
+	ticker := time.NewTicker(r.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.shutdownCh:
+			return
+		case <-ticker.C:
+			if err := r.refresh(); err != nil {
+				// keep serving the last known mapping
+				r.logger.Warn("failed to refresh domains", tag.Error(err))
+			}
+		}
+	}
+}
+
+func (r *cachedDomainResolver) refresh() error {
+	nameToID, err := r.source.LoadDomains()
+	if err != nil {
+		// not retried by lookups of unknown domains until domainMissRefreshInterval
+		r.Lock()
+		r.lastRefresh = time.Now()
+		r.Unlock()
+		return err
+	}
+	idToName := make(map[string]string, len(nameToID))
+	for name, id := range nameToID {
+		idToName[id] = name
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.nameToID = nameToID
+	r.idToName = idToName
+	r.lastRefresh = time.Now()
+	return nil
+}
+
+func (s *staticDomainSource) LoadDomains() (map[string]string, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	domains := make(map[string]string)
+	if err := yaml.Unmarshal(content, &domains); err != nil {
+		return nil, fmt.Errorf("invalid domain mapping file %v: %v", s.path, err)
+	}
+	return domains, nil
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber-go/tally"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log/loggerimpl"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+// fakeDomainSource serves an in-memory mapping and counts the loads
+type fakeDomainSource struct {
+	sync.Mutex
+	domains map[string]string
+	loads   int
+}
+
+func (s *fakeDomainSource) LoadDomains() (map[string]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.loads++
+	domains := make(map[string]string, len(s.domains))
+	for name, id := range s.domains {
+		domains[name] = id
+	}
+	return domains, nil
+}
+
+func (s *fakeDomainSource) add(name, id string) {
+	s.Lock()
+	defer s.Unlock()
+	s.domains[name] = id
+}
+
+func (s *fakeDomainSource) getLoads() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.loads
+}
+
+func newTestDomainResolver(t *testing.T, source DomainSource) *cachedDomainResolver {
+	r := newCachedDomainResolver(source, time.Hour, loggerimpl.NewNopLogger())
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Stop)
+	return r
+}
+
+func TestCachedDomainResolver(t *testing.T) {
+	source := &fakeDomainSource{domains: map[string]string{"samples-domain": "id-1"}}
+	r := newTestDomainResolver(t, source)
+
+	if name, ok := r.GetDomainName("id-1"); !ok || name != "samples-domain" {
+		t.Errorf("GetDomainName(id-1) = %q, %v", name, ok)
+	}
+	if id, ok := r.GetDomainID("samples-domain"); !ok || id != "id-1" {
+		t.Errorf("GetDomainID(samples-domain) = %q, %v", id, ok)
+	}
+
+	// unknown domains don't refresh again right after a refresh
+	source.add("new-domain", "id-2")
+	if _, ok := r.GetDomainName("id-2"); ok {
+		t.Error("expected id-2 to be unknown until the mapping is refreshed")
+	}
+	if loads := source.getLoads(); loads != 1 {
+		t.Errorf("expected 1 load, got %v", loads)
+	}
+
+	// a new domain is picked up by refreshing on the miss
+	r.Lock()
+	r.lastRefresh = time.Now().Add(-domainMissRefreshInterval)
+	r.Unlock()
+	if name, ok := r.GetDomainName("id-2"); !ok || name != "new-domain" {
+		t.Errorf("GetDomainName(id-2) = %q, %v", name, ok)
+	}
+	if _, ok := r.GetDomainID("missing-domain"); ok {
+		t.Error("expected missing-domain to be unknown")
+	}
+	if loads := source.getLoads(); loads != 2 {
+		t.Errorf("expected 2 loads, got %v", loads)
+	}
+}
+
+func TestStaticDomainSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.yaml")
+	if err := ioutil.WriteFile(path, []byte("samples-domain: id-1\nother-domain: id-2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestDomainResolver(t, newStaticDomainSource(path))
+	if name, ok := r.GetDomainName("id-2"); !ok || name != "other-domain" {
+		t.Errorf("GetDomainName(id-2) = %q, %v", name, ok)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("- not a mapping"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newStaticDomainSource(path).LoadDomains(); err == nil {
+		t.Error("expected an error for an invalid mapping file")
+	}
+	if _, err := newStaticDomainSource(filepath.Join(os.TempDir(), "missing", "domains.yaml")).LoadDomains(); err == nil {
+		t.Error("expected an error for a missing mapping file")
+	}
+}
+
+func TestIsDomainSelected(t *testing.T) {
+	source := &fakeDomainSource{domains: map[string]string{"samples-domain": "id-1", "other-domain": "id-2"}}
+	r := newTestDomainResolver(t, source)
+	subscriber := &config.Subscriber{Name: "test"}
+	subscriber.Filter.SelectedDomains = []string{"samples-domain"}
+	scope := tally.NewTestScope("", nil)
+	p, err := newNotifierWithSink(&cconfig.KafkaConfig{}, subscriber, r, nil, loggerimpl.NewNopLogger(), scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"id-1":    true,
+		"id-2":    false,
+		"id-miss": false,
+	}
+	for domainID, want := range tests {
+		if got := p.isDomainSelected(domainID); got != want {
+			t.Errorf("isDomainSelected(%v) = %v, want %v", domainID, got, want)
+		}
+	}
+	var unknown int64
+	for _, counter := range scope.Snapshot().Counters() {
+		if counter.Name() == unknownDomains {
+			unknown += counter.Value()
+		}
+	}
+	if unknown != 1 {
+		t.Errorf("expected 1 message of an unknown domain to be counted, got %v", unknown)
+	}
+}
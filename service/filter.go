@@ -0,0 +1,584 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	es "github.com/uber/cadence/common/elasticsearch"
+	"github.com/uber/cadence/common/types"
+)
+
+type (
+	// filterExpression is a compiled boolean expression for filtering notifications per subscriber, e.g.
+	//
+	//	op == "RecordClosed" && SearchAttributes.CloseStatus != 0 && WorkflowType.startsWith("payments.")
+	//
+	// Supported syntax:
+	//   - literals: "string", 'string', numbers, true, false, null, [list, of, literals]
+	//   - operators: || && ! == != < <= > >= in, and parentheses
+	//   - string methods: startsWith, endsWith, contains, matches (regular expression)
+	//   - fields: see filterFields, plus SearchAttributes.<key> and SearchAttributes["<key>"]
+	//
+	// Evaluation never fails: a missing field is null, and comparing or calling a method on values
+	// of the wrong type is simply false.
+	filterExpression struct {
+		source string
+		root   filterNode
+	}
+
+	filterNode interface {
+		eval(n *Notification) interface{}
+	}
+
+	filterLiteral struct {
+		value interface{}
+	}
+
+	filterList struct {
+		items []filterNode
+	}
+
+	filterField struct {
+		get  func(n *Notification) interface{}
+		keys []string
+	}
+
+	filterNot struct {
+		operand filterNode
+	}
+
+	filterLogical struct {
+		op          string
+		left, right filterNode
+	}
+
+	filterCompare struct {
+		op          string
+		left, right filterNode
+	}
+
+	filterMethod struct {
+		name     string
+		receiver filterNode
+		arg      string
+		regex    *regexp.Regexp
+	}
+
+	filterToken struct {
+		kind  filterTokenKind
+		text  string
+		value interface{}
+		pos   int
+	}
+
+	filterTokenKind int
+
+	filterParser struct {
+		tokens []filterToken
+		pos    int
+	}
+)
+
+const (
+	tokenEOF filterTokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+// filterFields are the top level fields that can be referenced in a filter expression
+var filterFields = map[string]func(n *Notification) interface{}{
+	"op":                  func(n *Notification) interface{} { return string(n.VisibilityOperation) },
+	"VisibilityOperation": func(n *Notification) interface{} { return string(n.VisibilityOperation) },
+	"ID":                  func(n *Notification) interface{} { return n.ID },
+	"DomainID":            func(n *Notification) interface{} { return n.DomainID },
+	"DomainName":          func(n *Notification) interface{} { return n.DomainName },
+	"WorkflowID":          func(n *Notification) interface{} { return n.WorkflowID },
+	"RunID":               func(n *Notification) interface{} { return n.RunID },
+	"WorkflowType":        func(n *Notification) interface{} { return n.WorkflowType },
+	"TaskList":            func(n *Notification) interface{} { return n.SearchAttributes[es.TaskList] },
+	// looked up from the search attributes rather than Notification.CloseStatus, which is only set for closed workflows
+	"CloseStatus": func(n *Notification) interface{} {
+		status, ok := n.SearchAttributes[es.CloseStatus].(int64)
+		if !ok {
+			return nil
+		}
+		return types.WorkflowExecutionCloseStatus(status).String()
+	},
+	"HistoryLength":    func(n *Notification) interface{} { return n.HistoryLength },
+	"IsCron":           func(n *Notification) interface{} { return n.IsCron },
+	"NumClusters":      func(n *Notification) interface{} { return n.NumClusters },
+	"SearchAttributes": func(n *Notification) interface{} { return n.SearchAttributes },
+}
+
+var filterCompareOperators = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+var filterMethods = map[string]func(s, arg string) bool{
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+	"contains":   strings.Contains,
+	"matches":    nil, // evaluated with the regex compiled in advance
+}
+
+// compileFilterExpression parses the expression, returns nil if the expression is empty
+func compileFilterExpression(source string) (*filterExpression, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	tokens, err := tokenizeFilter(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression %q: %v", source, err)
+	}
+	parser := &filterParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err == nil && parser.peek().kind != tokenEOF {
+		err = parser.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression %q: %v", source, err)
+	}
+	return &filterExpression{source: source, root: root}, nil
+}
+
+// Match returns true if the notification satisfies the expression
+func (f *filterExpression) Match(n *Notification) bool {
+	return isTrue(f.root.eval(n))
+}
+
+func (f *filterExpression) String() string {
+	return f.source
+}
+
+func tokenizeFilter(source string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: string(runes[start:i]), pos: start})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E') {
+				// the exponent may be signed, e.g. 1e-5
+				if (runes[i] == 'e' || runes[i] == 'E') && i+1 < len(runes) && (runes[i+1] == '-' || runes[i+1] == '+') {
+					i++
+				}
+				i++
+			}
+			text := string(runes[start:i])
+			number, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %v", text, start)
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: text, value: number, pos: start})
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					switch runes[i] {
+					case 'n':
+						sb.WriteRune('\n')
+					case 't':
+						sb.WriteRune('\t')
+					default:
+						sb.WriteRune(runes[i])
+					}
+					continue
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %v", start)
+			}
+			i++
+			tokens = append(tokens, filterToken{kind: tokenString, text: string(runes[start:i]), value: sb.String(), pos: start})
+		default:
+			start := i
+			operator := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ".", ","} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %v", r, start)
+			}
+			i += len(operator)
+			tokens = append(tokens, filterToken{kind: tokenOperator, text: operator, pos: start})
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *filterParser) accept(kind filterTokenKind, text string) bool {
+	if token := p.peek(); token.kind == kind && token.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(text string) error {
+	if !p.accept(tokenOperator, text) {
+		return fmt.Errorf("expected %q at position %v", text, p.peek().pos)
+	}
+	return nil
+}
+
+func (p *filterParser) unexpected() error {
+	token := p.peek()
+	if token.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at position %v", token.text, token.pos)
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokenOperator, "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterLogical{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokenOperator, "&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterLogical{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.accept(tokenOperator, "!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *filterParser) parseCompare() (filterNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	token := p.peek()
+	isCompare := token.kind == tokenOperator && filterCompareOperators[token.text]
+	if !isCompare && !(token.kind == tokenIdent && token.text == "in") {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if token.text == "in" {
+		if _, ok := right.(*filterList); !ok {
+			return nil, fmt.Errorf("right side of \"in\" at position %v must be a list", token.pos)
+		}
+	}
+	return &filterCompare{op: token.text, left: left, right: right}, nil
+}
+
+func (p *filterParser) parsePrimary() (filterNode, error) {
+	token := p.peek()
+	switch token.kind {
+	case tokenString, tokenNumber:
+		p.next()
+		return &filterLiteral{value: token.value}, nil
+	case tokenIdent:
+		p.next()
+		switch token.text {
+		case "true":
+			return &filterLiteral{value: true}, nil
+		case "false":
+			return &filterLiteral{value: false}, nil
+		case "null":
+			return &filterLiteral{value: nil}, nil
+		}
+		return p.parseField(token)
+	case tokenOperator:
+		switch token.text {
+		case "(":
+			p.next()
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		case "[":
+			p.next()
+			list := &filterList{}
+			for !p.accept(tokenOperator, "]") {
+				if len(list.items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+				if _, ok := item.(*filterLiteral); !ok {
+					return nil, fmt.Errorf("list items must be literals, at position %v", token.pos)
+				}
+				list.items = append(list.items, item)
+			}
+			return list, nil
+		}
+	}
+	return nil, p.unexpected()
+}
+
+// parseField parses a field reference with optional map keys and an optional trailing method call
+func (p *filterParser) parseField(ident filterToken) (filterNode, error) {
+	get, ok := filterFields[ident.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q at position %v", ident.text, ident.pos)
+	}
+	field := &filterField{get: get}
+	for {
+		switch {
+		case p.accept(tokenOperator, "."):
+			name := p.peek()
+			if name.kind != tokenIdent {
+				return nil, p.unexpected()
+			}
+			p.next()
+			if p.peek().kind == tokenOperator && p.peek().text == "(" {
+				return p.parseMethod(field, name)
+			}
+			field.keys = append(field.keys, name.text)
+		case p.accept(tokenOperator, "["):
+			key := p.peek()
+			if key.kind != tokenString {
+				return nil, p.unexpected()
+			}
+			p.next()
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			field.keys = append(field.keys, key.value.(string))
+		default:
+			return field, nil
+		}
+	}
+}
+
+func (p *filterParser) parseMethod(receiver filterNode, name filterToken) (filterNode, error) {
+	if _, ok := filterMethods[name.text]; !ok {
+		return nil, fmt.Errorf("unknown method %q at position %v", name.text, name.pos)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	arg := p.next()
+	if arg.kind != tokenString {
+		return nil, fmt.Errorf("method %q at position %v takes a single string literal", name.text, name.pos)
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	method := &filterMethod{name: name.text, receiver: receiver, arg: arg.value.(string)}
+	if name.text == "matches" {
+		regex, err := regexp.Compile(method.arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at position %v: %v", arg.pos, err)
+		}
+		method.regex = regex
+	}
+	return method, nil
+}
+
+func (l *filterLiteral) eval(_ *Notification) interface{} {
+	return l.value
+}
+
+func (l *filterList) eval(n *Notification) interface{} {
+	values := make([]interface{}, len(l.items))
+	for i, item := range l.items {
+		values[i] = item.eval(n)
+	}
+	return values
+}
+
+func (f *filterField) eval(n *Notification) interface{} {
+	value := f.get(n)
+	for _, key := range f.keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return normalizeFilterValue(value)
+}
+
+func (f *filterNot) eval(n *Notification) interface{} {
+	return !isTrue(f.operand.eval(n))
+}
+
+func (f *filterLogical) eval(n *Notification) interface{} {
+	left := isTrue(f.left.eval(n))
+	if f.op == "&&" {
+		return left && isTrue(f.right.eval(n))
+	}
+	return left || isTrue(f.right.eval(n))
+}
+
+func (f *filterCompare) eval(n *Notification) interface{} {
+	left, right := f.left.eval(n), f.right.eval(n)
+	switch f.op {
+	case "==":
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		return !reflect.DeepEqual(left, right)
+	case "in":
+		for _, item := range right.([]interface{}) {
+			if reflect.DeepEqual(left, item) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		cmp = compareFloat(l, r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+	switch f.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func (f *filterMethod) eval(n *Notification) interface{} {
+	s, ok := f.receiver.eval(n).(string)
+	if !ok {
+		return false
+	}
+	if f.regex != nil {
+		return f.regex.MatchString(s)
+	}
+	return filterMethods[f.name](s, f.arg)
+}
+
+// normalizeFilterValue converts all numbers to float64 so that they compare with number literals
+func normalizeFilterValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeFilterValue(item)
+		}
+		return normalized
+	default:
+		return value
+	}
+}
+
+func compareFloat(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isTrue(value interface{}) bool {
+	b, ok := value.(bool)
+	return ok && b
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/uber/cadence/common"
+)
+
+func newFilterTestNotification() *Notification {
+	return &Notification{
+		VisibilityOperation: common.RecordClosed,
+		DomainName:          "samples-domain",
+		WorkflowID:          "order-123",
+		WorkflowType:        "payments.Charge",
+		CloseStatus:         "FAILED",
+		HistoryLength:       11,
+		SearchAttributes: map[string]interface{}{
+			"CloseStatus":        int64(1),
+			"TaskList":           "payments-tasklist",
+			"CustomIntField":     int64(5),
+			"CustomDoubleField":  150.0,
+			"CustomKeywordField": "gold",
+			"CustomBoolField":    true,
+			"Tags":               []interface{}{int64(1), "vip"},
+			"Nested":             map[string]interface{}{"Level": int64(2)},
+		},
+	}
+}
+
+func TestFilterExpressionMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+	}{
+		// precedence: ! binds tighter than &&, which binds tighter than ||
+		{"and before or", `true || false && false`, true},
+		{"and before or on the right", `false && false || true`, true},
+		{"parentheses", `(true || false) && false`, false},
+		{"not before and", `!false && false`, false},
+		{"not of parentheses", `!(false && false)`, true},
+		{"double not", `!!true`, true},
+		{"compare before and", `HistoryLength > 10 && CloseStatus == "FAILED"`, true},
+
+		{"in strings", `WorkflowType in ["payments.Refund", "payments.Charge"]`, true},
+		{"not in strings", `WorkflowType in ["payments.Refund"]`, false},
+		{"in numbers", `HistoryLength in [1, 11]`, true},
+		{"in empty list", `HistoryLength in []`, false},
+		{"in with mixed types", `SearchAttributes.CustomKeywordField in [1, "gold"]`, true},
+		{"in list value", `SearchAttributes.Tags == [1, "vip"]`, true},
+
+		{"matches", `WorkflowID.matches("^order-[0-9]+$")`, true},
+		{"matches partially", `WorkflowID.matches("[0-9]{3}")`, true},
+		{"not matches", `WorkflowID.matches("^refund-")`, false},
+		{"matches non-string", `HistoryLength.matches("1")`, false},
+		{"startsWith", `WorkflowType.startsWith("payments.")`, true},
+		{"endsWith", `WorkflowType.endsWith("Refund")`, false},
+		{"contains", `DomainName.contains("samples")`, true},
+
+		{"missing search attribute is null", `SearchAttributes.Missing == null`, true},
+		{"missing search attribute by key", `SearchAttributes["Missing"] != null`, false},
+		{"missing nested key", `SearchAttributes.CustomIntField.Level == null`, true},
+		{"compare missing", `SearchAttributes.Missing > 1`, false},
+		{"compare missing reversed", `SearchAttributes.Missing <= 1`, false},
+		{"method on missing", `SearchAttributes.Missing.startsWith("a")`, false},
+		{"task list", `TaskList == "payments-tasklist"`, true},
+		{"missing in list", `SearchAttributes.Missing in [null]`, true},
+
+		{"int64 equals integer literal", `SearchAttributes.CustomIntField == 5`, true},
+		{"int64 equals float literal", `SearchAttributes.CustomIntField == 5.0`, true},
+		{"nested int64", `SearchAttributes.Nested.Level >= 2`, true},
+		{"float compare", `SearchAttributes.CustomDoubleField > 149.5`, true},
+		{"exponent", `SearchAttributes.CustomDoubleField == 1.5e2`, true},
+		{"signed exponent", `SearchAttributes.CustomDoubleField == 1.5e+2`, true},
+		{"negative exponent", `1e-5 < 0.001`, true},
+		{"upper case exponent", `2.5E-3 == 0.0025`, true},
+		{"negative number", `-2 < 0`, true},
+		{"number vs string", `SearchAttributes.CustomIntField == "5"`, false},
+		{"number order vs string", `SearchAttributes.CustomKeywordField > 1`, false},
+		{"string order", `"abc" < "abd"`, true},
+		{"bool field", `SearchAttributes.CustomBoolField == true`, true},
+		{"non-bool is not true", `SearchAttributes.CustomKeywordField`, false},
+		{"op alias", `op == "RecordClosed" && VisibilityOperation == op`, true},
+		{"single quotes and escapes", `'a\'b' == "a'b"`, true},
+	}
+	notification := newFilterTestNotification()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := compileFilterExpression(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Match(notification); got != test.want {
+				t.Errorf("%v: got %v, want %v", test.expression, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFilterCloseStatus(t *testing.T) {
+	f, err := compileFilterExpression(`CloseStatus == "FAILED"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CloseStatus comes from the search attributes, even without ClosedTimestamp
+	notification := &Notification{SearchAttributes: map[string]interface{}{"CloseStatus": int64(1)}}
+	if !f.Match(notification) {
+		t.Error("expected CloseStatus to be looked up from the search attributes")
+	}
+	notification = &Notification{CloseStatus: "FAILED"}
+	if f.Match(notification) {
+		t.Error("expected CloseStatus to be null without the search attribute")
+	}
+
+	f, err = compileFilterExpression(`CloseStatus == null && TaskList == null`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Match(&Notification{}) {
+		t.Error("expected missing CloseStatus and TaskList to be null")
+	}
+}
+
+func TestCompileFilterExpressionErrors(t *testing.T) {
+	tests := []string{
+		`UnknownField == 1`,
+		`WorkflowID == "unterminated`,
+		`WorkflowID in "not a list"`,
+		`WorkflowID in [WorkflowType]`,
+		`WorkflowID.unknownMethod("a")`,
+		`WorkflowID.matches("[")`,
+		`WorkflowID.startsWith(1)`,
+		`(true || false`,
+		`true false`,
+		`true &&`,
+		`HistoryLength > 1e-`,
+		`1.2.3 == 1`,
+		`WorkflowID # 1`,
+		`SearchAttributes[1] == 1`,
+	}
+	for _, expression := range tests {
+		if _, err := compileFilterExpression(expression); err == nil {
+			t.Errorf("expected %v to fail to compile", expression)
+		}
+	}
+}
+
+func TestCompileEmptyFilterExpression(t *testing.T) {
+	f, err := compileFilterExpression("  ")
+	if err != nil || f != nil {
+		t.Errorf("expected no filter for an empty expression, got %v, %v", f, err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/uber/cadence/common/authorization"
+	cconfig "github.com/uber/cadence/common/config"
+)
+
+// same default as the Cadence Kafka client
+const defaultKafkaVersion = "0.10.2.0"
+
+// newSaramaConfig builds the sarama config for talking to the Kafka clusters directly,
+// for features that the Cadence Kafka client doesn't expose(e.g. message headers).
+// It applies the same version, TLS and SASL settings as the Cadence Kafka client.
+func newSaramaConfig(kafkaConfig *cconfig.KafkaConfig) (*sarama.Config, error) {
+	kafkaVersion := kafkaConfig.Version
+	if kafkaVersion == "" {
+		kafkaVersion = defaultKafkaVersion
+	}
+	version, err := sarama.ParseKafkaVersion(kafkaVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Version = version
+
+	tlsConfig, err := kafkaConfig.TLS.ToTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error creating Kafka TLS config: %v", err)
+	}
+	saramaConfig.Net.TLS.Enable = tlsConfig != nil
+	saramaConfig.Net.TLS.Config = tlsConfig
+
+	saramaConfig.Net.SASL.Enable = kafkaConfig.SASL.Enabled
+	saramaConfig.Net.SASL.User = kafkaConfig.SASL.User
+	saramaConfig.Net.SASL.Password = kafkaConfig.SASL.Password
+	saramaConfig.Net.SASL.Handshake = true
+	if kafkaConfig.SASL.Enabled {
+		switch kafkaConfig.SASL.Algorithm {
+		case "sha512":
+			saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &authorization.XDGSCRAMClient{HashGeneratorFcn: authorization.SHA512}
+			}
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		case "sha256":
+			saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &authorization.XDGSCRAMClient{HashGeneratorFcn: authorization.SHA256}
+			}
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		case "plain":
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		default:
+			return nil, fmt.Errorf("invalid SHA algorithm %s: can be either sha256 or sha512", kafkaConfig.SASL.Algorithm)
+		}
+	}
+	return saramaConfig, nil
+}
+
+// getBrokersForTopic returns the brokers of the cluster that has the topic
+func getBrokersForTopic(kafkaConfig *cconfig.KafkaConfig, topic string) ([]string, error) {
+	topicConfig, ok := kafkaConfig.Topics[topic]
+	if !ok {
+		return nil, fmt.Errorf("missing kafka.topics config for topic %v", topic)
+	}
+	brokers := kafkaConfig.GetBrokersForKafkaCluster(topicConfig.Cluster)
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("missing kafka.clusters brokers config for cluster %v", topicConfig.Cluster)
+	}
+	return brokers, nil
+}
+
+// newSyncProducer creates a producer for the topic, which supports message headers
+func newSyncProducer(kafkaConfig *cconfig.KafkaConfig, topic string) (sarama.SyncProducer, error) {
+	brokers, err := getBrokersForTopic(kafkaConfig, topic)
+	if err != nil {
+		return nil, err
+	}
+	saramaConfig, err := newSaramaConfig(kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+	// message headers require Kafka 0.11+
+	if !saramaConfig.Version.IsAtLeast(sarama.V0_11_0_0) {
+		saramaConfig.Version = sarama.V0_11_0_0
+	}
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	return sarama.NewSyncProducer(brokers, saramaConfig)
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/uber-go/tally"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+// DeliveryMethodKafka publishes notifications as JSON to a Kafka topic
+const DeliveryMethodKafka = "kafka"
+
+// Headers of the messages published by the kafka delivery method.
+// The message key is the workflowID, the value is the JSON notification, or a CloudEvent if the subscriber's format is cloudevents.
+const (
+	// KafkaHeaderOperation is the visibility operation, e.g. RecordClosed
+	KafkaHeaderOperation = "cadence-notification-operation"
+	// KafkaHeaderDomainID is the domain ID of the workflow
+	KafkaHeaderDomainID = "cadence-notification-domain-id"
+	// KafkaHeaderDomainName is the domain name of the workflow, omitted if it can't be resolved
+	KafkaHeaderDomainName = "cadence-notification-domain-name"
+)
+
+type (
+	kafkaSinkFactory struct{}
+
+	// kafkaSink publishes notifications to a downstream Kafka topic.
+	// It uses a sarama producer rather than the Cadence messaging client, which can't publish JSON with headers.
+	kafkaSink struct {
+		topic       string
+		producer    sarama.SyncProducer
+		retryPolicy *retryPolicy
+		// nil if the subscriber's format isn't cloudevents
+		cloudEvents *cloudEventsEncoder
+
+		logger      log.Logger
+		metricScope tally.Scope
+	}
+)
+
+var _ Sink = (*kafkaSink)(nil)
+
+func init() {
+	RegisterSink(DeliveryMethodKafka, &kafkaSinkFactory{})
+}
+
+func (f *kafkaSinkFactory) ValidateConfig(subscriber *config.Subscriber, kafkaConfig *cconfig.KafkaConfig) error {
+	kafkaDelivery := &subscriber.Delivery.Kafka
+	if kafkaDelivery.Topic == "" {
+		return fmt.Errorf("kafka.topic is required")
+	}
+	if _, err := getBrokersForTopic(kafkaConfig, kafkaDelivery.Topic); err != nil {
+		return err
+	}
+	if _, err := newRetryPolicy(&kafkaDelivery.RetryPolicy); err != nil {
+		return fmt.Errorf("invalid kafka retry policy: %v", err)
+	}
+	return nil
+}
+
+func (f *kafkaSinkFactory) NewSink(params *SinkParams) (Sink, error) {
+	kafkaDelivery := &params.Subscriber.Delivery.Kafka
+	retryPolicy, err := newRetryPolicy(&kafkaDelivery.RetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+	cloudEvents, err := newCloudEventsEncoder(params.Subscriber)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := newSyncProducer(params.KafkaConfig, kafkaDelivery.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create producer for topic %v: %v", kafkaDelivery.Topic, err)
+	}
+	return &kafkaSink{
+		topic:       kafkaDelivery.Topic,
+		producer:    producer,
+		retryPolicy: retryPolicy,
+		cloudEvents: cloudEvents,
+		logger:      params.Logger.WithTags(tag.KafkaTopicName(kafkaDelivery.Topic)),
+		metricScope: params.MetricScope,
+	}, nil
+}
+
+func (s *kafkaSink) Start() error {
+	return nil
+}
+
+func (s *kafkaSink) Stop() {
+	if err := s.producer.Close(); err != nil {
+		s.logger.Warn("failed to close producer", tag.Error(err))
+	}
+}
+
+// breaksPerAttempt is true as Deliver retries with retryDelivery
+func (s *kafkaSink) breaksPerAttempt() bool {
+	return true
+}
+
+func (s *kafkaSink) Deliver(ctx context.Context, notification *Notification) error {
+	msg := &sarama.ProducerMessage{
+		Topic: s.topic,
+		// same key as the visibility topic so that notifications of a workflow stay in order
+		Key: sarama.StringEncoder(notification.WorkflowID),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(KafkaHeaderOperation), Value: []byte(notification.VisibilityOperation)},
+			{Key: []byte(KafkaHeaderDomainID), Value: []byte(notification.DomainID)},
+		},
+	}
+	if notification.DomainName != "" {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(KafkaHeaderDomainName), Value: []byte(notification.DomainName)})
+	}
+	if s.cloudEvents == nil {
+		jsonBytes, err := json.Marshal(notification)
+		if err != nil {
+			return &DeliveryError{Err: err, Attempts: 1}
+		}
+		msg.Value = sarama.ByteEncoder(jsonBytes)
+	} else {
+		// Kafka protocol binding of CloudEvents
+		event, err := s.cloudEvents.encode(notification)
+		if err != nil {
+			return &DeliveryError{Err: err, Attempts: 1}
+		}
+		msg.Value = sarama.ByteEncoder(event.body)
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte("content-type"), Value: []byte(event.contentType)})
+		for name, value := range event.attributes {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte("ce_" + name), Value: []byte(value)})
+		}
+	}
+
+	return retryDelivery(ctx, s.retryPolicy, isRetryableKafkaError, s.metricScope, func(_ int) error {
+		_, _, err := s.producer.SendMessage(msg)
+		return err
+	})
+}
+
+// isRetryableKafkaError returns false for errors that won't go away by publishing the same message again
+func isRetryableKafkaError(err error) bool {
+	switch {
+	case errors.Is(err, sarama.ErrMessageSizeTooLarge),
+		errors.Is(err, sarama.ErrInvalidMessage),
+		errors.Is(err, sarama.ErrUnknownTopicOrPartition),
+		errors.Is(err, sarama.ErrTopicAuthorizationFailed):
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+type (
+	// deliveryLimiter caps the rate and the concurrency of deliveries, the limits can be changed while waiting
+	deliveryLimiter struct {
+		lock        sync.Mutex
+		limits      deliveryLimits
+		rateLimiter *rate.Limiter
+		inFlight    int
+		// closed and replaced when a delivery finishes or the limits change
+		changed chan struct{}
+	}
+
+	// deliveryLimits is the effective DeliveryLimits, reported by the admin server
+	deliveryLimits struct {
+		RPS         float64 `json:"rps"`
+		Burst       int     `json:"burst"`
+		MaxInFlight int     `json:"maxInFlight"`
+	}
+
+	// deliveryLimitsUpdate changes the limits of a subscriber via the admin server, nil fields are unchanged
+	deliveryLimitsUpdate struct {
+		RPS         *float64 `json:"rps"`
+		Burst       *int     `json:"burst"`
+		MaxInFlight *int     `json:"maxInFlight"`
+	}
+)
+
+func validateDeliveryLimits(cfg *config.DeliveryLimits) error {
+	if cfg.RPS < 0 {
+		return fmt.Errorf("delivery.limits.rps must not be negative")
+	}
+	if cfg.Burst < 0 {
+		return fmt.Errorf("delivery.limits.burst must not be negative")
+	}
+	if cfg.MaxInFlight < 0 {
+		return fmt.Errorf("delivery.limits.maxInFlight must not be negative")
+	}
+	return nil
+}
+
+func newDeliveryLimiter(cfg *config.DeliveryLimits) *deliveryLimiter {
+	l := &deliveryLimiter{
+		rateLimiter: rate.NewLimiter(rate.Inf, 0),
+		changed:     make(chan struct{}),
+	}
+	l.setLimits(deliveryLimits{RPS: cfg.RPS, Burst: cfg.Burst, MaxInFlight: cfg.MaxInFlight})
+	return l
+}
+
+// acquire waits for an in-flight slot and a rate token, release must be called after the delivery if it returns nil.
+// It returns true in throttled if it had to wait.
+func (l *deliveryLimiter) acquire(ctx context.Context) (throttled bool, err error) {
+	for {
+		l.lock.Lock()
+		if l.limits.MaxInFlight == 0 || l.inFlight < l.limits.MaxInFlight {
+			l.inFlight++
+			l.lock.Unlock()
+			break
+		}
+		changed := l.changed
+		l.lock.Unlock()
+
+		throttled = true
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return throttled, ctx.Err()
+		}
+	}
+
+	reservation := l.rateLimiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		throttled = true
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			reservation.Cancel()
+			l.release()
+			return throttled, ctx.Err()
+		}
+	}
+	return throttled, nil
+}
+
+func (l *deliveryLimiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.inFlight--
+	l.notifyChanged()
+}
+
+func (l *deliveryLimiter) getLimits() deliveryLimits {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limits
+}
+
+// update applies the non-nil fields of the update and returns the new limits
+func (l *deliveryLimiter) update(update *deliveryLimitsUpdate) (deliveryLimits, error) {
+	limits := l.getLimits()
+	if update.RPS != nil {
+		limits.RPS = *update.RPS
+		if update.Burst == nil {
+			// recomputed from the new rps
+			limits.Burst = 0
+		}
+	}
+	if update.Burst != nil {
+		limits.Burst = *update.Burst
+	}
+	if update.MaxInFlight != nil {
+		limits.MaxInFlight = *update.MaxInFlight
+	}
+	if err := validateDeliveryLimits(&config.DeliveryLimits{RPS: limits.RPS, Burst: limits.Burst, MaxInFlight: limits.MaxInFlight}); err != nil {
+		return limits, err
+	}
+	l.setLimits(limits)
+	return l.getLimits(), nil
+}
+
+func (l *deliveryLimiter) setLimits(limits deliveryLimits) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if limits.RPS > 0 && limits.Burst == 0 {
+		limits.Burst = int(math.Ceil(limits.RPS))
+	}
+	l.limits = limits
+	if limits.RPS > 0 {
+		l.rateLimiter.SetLimit(rate.Limit(limits.RPS))
+		l.rateLimiter.SetBurst(limits.Burst)
+	} else {
+		l.rateLimiter.SetLimit(rate.Inf)
+	}
+	l.notifyChanged()
+}
+
+func (l *deliveryLimiter) notifyChanged() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
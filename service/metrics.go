@@ -21,6 +21,18 @@
 package service
 
 const (
-	processLatency = "process-latency"
-	corruptedData = "corrupted-data"
-)
\ No newline at end of file
+	processLatency       = "process-latency"
+	corruptedData        = "corrupted-data"
+	filteredMessages     = "filtered-messages"
+	unknownDomains       = "unknown-domains"
+	deliveryRetries      = "delivery-retries"
+	deliveryFailures     = "delivery-failures"
+	dlqMessages          = "dlq-messages"
+	dlqPublishFailures   = "dlq-publish-failures"
+	duplicatesSuppressed = "duplicates-suppressed"
+	webhookBatches       = "webhook-batches"
+	throttledDeliveries  = "throttled-deliveries"
+	circuitBreakerState  = "circuit-breaker-state"
+	circuitBreakerOpened = "circuit-breaker-opened"
+	webhookResponses     = "webhook-responses"
+)
@@ -1,17 +1,29 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"time"
 
 	"github.com/uber/cadence/common"
 )
 
+// NotificationSchemaVersion is the version of the Notification payload, bumped on breaking changes
+const NotificationSchemaVersion = 1
+
 type (
 	Notification struct {
-		ID                  string
+		// version of the payload schema, see NotificationSchemaVersion
+		SchemaVersion int
+		// partition-offset of the visibility message, only unique within the visibility topic
+		ID string
+		// stable across re-emitted visibility records, retries, replays and redrives, see newIdempotencyKey
+		IdempotencyKey      string
 		VisibilityOperation common.VisibilityOperation
-		// TODO: replace with domainName, need to pass by Cadence server
-		DomainID         string
+		DomainID            string
+		// resolved from DomainID, empty if service.domainResolver is not configured or the domain is unknown
+		DomainName       string
 		WorkflowID       string
 		RunID            string
 		WorkflowType     string
@@ -19,7 +31,27 @@ type (
 		// the actual time that starting execution, this is used mainly for cron schedule workflow
 		ExecutionTimestamp *time.Time
 		ClosedTimestamp    *time.Time
-		SearchAttributes   map[string]interface{}
-		Memo               map[string]interface{}
+		// COMPLETED, FAILED, CANCELED, TERMINATED, CONTINUED_AS_NEW or TIMED_OUT. Empty if the workflow is not closed
+		CloseStatus string
+		// number of history events, 0 if the workflow is not closed
+		HistoryLength int64
+		TaskList      string
+		IsCron        bool
+		NumClusters   int64
+		// ClosedTimestamp - StartedTimestamp, nanoseconds in JSON. Nil if the workflow is not closed
+		Duration *time.Duration
+		// ExecutionTimestamp - StartedTimestamp, i.e. the delay before execution e.g. for cron schedules. Nil if unknown
+		QueueDelay       *time.Duration
+		SearchAttributes map[string]interface{}
+		Memo             map[string]interface{}
 	}
 )
+
+// newIdempotencyKey derives the key of a visibility event from the domain, run, operation and event.
+// The event is the close time in nanoseconds for RecordClosed and the start time for RecordStarted.
+// Upserts and messages missing the time use the version of the visibility message instead,
+// or the notification ID(partition and offset of the message) if the version is missing too.
+func newIdempotencyKey(domainID, runID string, operation common.VisibilityOperation, event string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%v/%v/%v/%v", domainID, runID, operation, event)))
+	return hex.EncodeToString(sum[:16])
+}
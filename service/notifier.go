@@ -21,12 +21,10 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -35,63 +33,209 @@ import (
 	"github.com/uber-go/tally"
 	"github.com/uber/cadence/.gen/go/indexer"
 	"github.com/uber/cadence/common"
-	"github.com/uber/cadence/common/backoff"
 	"github.com/uber/cadence/common/codec"
+	cconfig "github.com/uber/cadence/common/config"
 	"github.com/uber/cadence/common/definition"
 	es "github.com/uber/cadence/common/elasticsearch"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/messaging"
+	"github.com/uber/cadence/common/persistence"
 	"github.com/uber/cadence/common/types"
 
 	"github.com/cadence-oss/cadence-notification/common/config"
 )
 
-const defaultConcurrency = 10
+const (
+	defaultConcurrency   = 10
+	defaultRetryInterval = time.Second
+	// interval for retrying to start a consumer after resuming a drained notifier
+	consumerRestartInterval = 10 * time.Second
+
+	subscriberTag = "subscriber"
+
+	memoEncodingRaw  = "raw"
+	memoEncodingJSON = "json"
+)
 
 // notifier consumes visibility message from Kafka topic and notifier external systems
 type notifier struct {
-	consumer         messaging.Consumer
+	// created by Start, nil while drained, only accessed by processorPump after Start
+	consumer messaging.Consumer
+	// creates a consumer when starting or resuming a drained notifier
+	newConsumer func() (messaging.Consumer, error)
+	// client of kafkaConfig, nil for notifiers that don't consume from the subscriber's consumer group
+	kafkaClient      messaging.Client
+	kafkaConfig      *cconfig.KafkaConfig
 	subscriberConfig *config.Subscriber
 	consumerConfig   *config.KafkaConsumer
-	httpClient       *http.Client
-	retryPolicy      *backoff.ExponentialRetryPolicy
-
-	msgEncoder  codec.BinaryEncoder
-	logger      log.Logger
-	metricScope tally.Scope
-
-	isStarted  int32
-	isStopped  int32
-	shutdownWG sync.WaitGroup
-	shutdownCh chan struct{}
+	sink             Sink
+	// nil if consumerGroupDlqTopic is not configured
+	dlqPublisher *dlqPublisher
+	// nil if deduplication is disabled
+	dedup   *dedupWindow
+	limiter *deliveryLimiter
+	// true if the sink applies the limits per request rather than the notifier per notification, see requestLimitedSink
+	limitsPerRequest bool
+	// nil if the circuit breaker is disabled
+	breaker        *circuitBreaker
+	domainResolver DomainResolver
+	// names of the selected domains, empty means selecting all
+	selectedDomains map[string]struct{}
+	// nil means selecting all
+	filterExpression *filterExpression
+	decodeMemo       bool
+
+	msgEncoder        codec.BinaryEncoder
+	payloadSerializer persistence.PayloadSerializer
+	logger            log.Logger
+	metricScope       tally.Scope
+
+	isStarted int32
+	isStopped int32
+	// set once the pump is started, for readiness checks. A drained notifier stays started without a consumer
+	isConsumerStarted int32
+	shutdownWG        sync.WaitGroup
+	shutdownCh        chan struct{}
+	// canceled on shutdown to interrupt the deliveries in progress
+	shutdownCtx    context.Context
+	shutdownCancel context.CancelFunc
+
+	// closed and reset on resume, nil if not paused
+	resumeCh   chan struct{}
+	pauseState string
+	pauseLock  sync.Mutex
+	// signaled when a drain is finished, so that processorPump stops the consumer
+	drainedC chan struct{}
+
+	// for reporting the state on the admin server
+	inFlight        int64
+	lastSuccessTime int64
+	lastErrorLock   sync.Mutex
+	lastError       string
+	lastErrorTime   time.Time
 }
 
 var (
 	errUnknownMessageType = &types.BadRequestError{Message: "unknown message type"}
+	// returned when giving up a delivery because of shutting down, the message should be neither acked nor nacked
+	errNotifierStopped = errors.New("notifier is stopped")
 )
 
-func newNotifier(kafkaClient messaging.Client, subscriberConfig *config.Subscriber, logger log.Logger, metricScope tally.Scope) (*notifier, error) {
-	consumerConfig := subscriberConfig.Consumer
-	consumer, err := kafkaClient.NewConsumer(subscriberConfig.Name, consumerConfig.ConsumerGroup)
-	exponentialRetryPolicy := backoff.NewExponentialRetryPolicy(subscriberConfig.Delivery.Webhook.RetryInterval)
-	exponentialRetryPolicy.SetMaximumAttempts(subscriberConfig.Delivery.Webhook.MaxRetries)
-
+func newNotifier(
+	kafkaClient messaging.Client,
+	kafkaConfig *cconfig.KafkaConfig,
+	subscriberConfig *config.Subscriber,
+	domainResolver DomainResolver,
+	logger log.Logger,
+	metricScope tally.Scope,
+) (*notifier, error) {
+	logger = logger.WithTags(tag.Name("Notifier-" + subscriberConfig.Name))
+	metricScope = metricScope.Tagged(map[string]string{subscriberTag: subscriberConfig.Name})
+	// the sink is created after validating the rest of the config, as it may connect to external systems
+	p, err := newNotifierWithSink(kafkaConfig, subscriberConfig, domainResolver, nil, logger, metricScope)
 	if err != nil {
 		return nil, err
 	}
-	return &notifier{
+	sink, err := newSink(&SinkParams{
+		Subscriber:  subscriberConfig,
+		KafkaConfig: kafkaConfig,
+		Logger:      logger,
+		MetricScope: metricScope,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("subscriber %v: %v", subscriberConfig.Name, err)
+	}
+	p.setSink(sink)
+
+	p.kafkaClient = kafkaClient
+	p.newConsumer = func() (messaging.Consumer, error) {
+		return kafkaClient.NewConsumer(subscriberConfig.Name, subscriberConfig.Consumer.ConsumerGroup)
+	}
+
+	// only live deliveries are deduplicated, replays are meant to deliver again
+	p.dedup = newDedupWindow(&subscriberConfig.Dedup)
+
+	if dlqTopic := subscriberConfig.Consumer.ConsumerGroupDlqTopic; dlqTopic != "" {
+		p.dlqPublisher, err = newDLQPublisher(kafkaConfig, dlqTopic)
+		if err != nil {
+			return nil, fmt.Errorf("subscriber %v: failed to create DLQ producer: %v", subscriberConfig.Name, err)
+		}
+	}
+	return p, nil
+}
+
+// newNotifierWithSink creates a notifier without consumer and DLQ, for processing messages
+// that don't come from the subscriber's consumer group, e.g. replays
+func newNotifierWithSink(
+	kafkaConfig *cconfig.KafkaConfig,
+	subscriberConfig *config.Subscriber,
+	domainResolver DomainResolver,
+	sink Sink,
+	logger log.Logger,
+	metricScope tally.Scope,
+) (*notifier, error) {
+	selectedDomains := make(map[string]struct{})
+	for _, domain := range subscriberConfig.Filter.SelectedDomains {
+		selectedDomains[domain] = struct{}{}
+	}
+	if len(selectedDomains) > 0 && domainResolver == nil {
+		return nil, fmt.Errorf("subscriber %v: filter.selectedDomains requires service.domainResolver to be configured", subscriberConfig.Name)
+	}
+	filterExpression, err := compileFilterExpression(subscriberConfig.Filter.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("subscriber %v: %v", subscriberConfig.Name, err)
+	}
+	if err := validateOrdering(subscriberConfig.Consumer.Ordering); err != nil {
+		return nil, fmt.Errorf("subscriber %v: %v", subscriberConfig.Name, err)
+	}
+	if err := validateMemoEncoding(subscriberConfig.MemoEncoding); err != nil {
+		return nil, fmt.Errorf("subscriber %v: %v", subscriberConfig.Name, err)
+	}
+	if err := validateDedup(&subscriberConfig.Dedup); err != nil {
+		return nil, fmt.Errorf("subscriber %v: %v", subscriberConfig.Name, err)
+	}
+	if err := validateFormat(subscriberConfig); err != nil {
+		return nil, fmt.Errorf("subscriber %v: %v", subscriberConfig.Name, err)
+	}
+	if err := validateDeliveryLimits(&subscriberConfig.Delivery.Limits); err != nil {
+		return nil, fmt.Errorf("subscriber %v: %v", subscriberConfig.Name, err)
+	}
+	if err := validateCircuitBreaker(&subscriberConfig.Delivery.CircuitBreaker); err != nil {
+		return nil, fmt.Errorf("subscriber %v: %v", subscriberConfig.Name, err)
+	}
+
+	consumerConfig := subscriberConfig.Consumer
+	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+	p := &notifier{
 		consumerConfig:   &consumerConfig,
-		consumer:         consumer,
+		kafkaConfig:      kafkaConfig,
 		subscriberConfig: subscriberConfig,
-		httpClient:       &http.Client{Timeout: subscriberConfig.Delivery.Webhook.CallbackRequestTimeout},
-		retryPolicy:      exponentialRetryPolicy,
-
-		msgEncoder:  codec.NewThriftRWEncoder(),
-		logger:      logger.WithTags(tag.Name("Notifier-" + subscriberConfig.Name)),
-		metricScope: metricScope,
-		shutdownCh:  make(chan struct{}),
-	}, nil
+		domainResolver:   domainResolver,
+		selectedDomains:  selectedDomains,
+		filterExpression: filterExpression,
+		decodeMemo:       subscriberConfig.MemoEncoding == memoEncodingJSON,
+		limiter:          newDeliveryLimiter(&subscriberConfig.Delivery.Limits),
+		breaker:          newCircuitBreaker(&subscriberConfig.Delivery.CircuitBreaker, logger, metricScope),
+
+		msgEncoder:        codec.NewThriftRWEncoder(),
+		payloadSerializer: persistence.NewPayloadSerializer(),
+		logger:            logger,
+		metricScope:       metricScope,
+		shutdownCh:        make(chan struct{}),
+		shutdownCtx:       shutdownCtx,
+		shutdownCancel:    shutdownCancel,
+		drainedC:          make(chan struct{}, 1),
+	}
+	p.setSink(sink)
+	return p, nil
+}
+
+func (p *notifier) setSink(sink Sink) {
+	p.sink = sink
+	if limited, ok := sink.(requestLimitedSink); ok {
+		p.limitsPerRequest = limited.limitRequests(p.limiter)
+	}
 }
 
 func (p *notifier) Start() error {
@@ -100,13 +244,37 @@ func (p *notifier) Start() error {
 	}
 	p.logger.Info("notifier state changed", tag.LifeCycleStarting)
 
-	if err := p.consumer.Start(); err != nil {
+	if err := p.sink.Start(); err != nil {
 		p.logger.Info("notifier state changed error", tag.LifeCycleStartFailed, tag.Error(err))
 		return err
 	}
+	topic := p.kafkaConfig.Applications[p.subscriberConfig.Name].Topic
+	if err := seedInitialOffsets(p.kafkaConfig, topic, p.consumerConfig.ConsumerGroup, p.consumerConfig.InitialOffset, p.logger); err != nil {
+		p.logger.Info("notifier state changed error", tag.LifeCycleStartFailed, tag.Error(err))
+		return fmt.Errorf("failed to apply consumer.initialOffset: %v", err)
+	}
+	// created here rather than with the notifier, as a consumer can't be closed before it's started.
+	// A drained notifier creates it when resumed
+	if p.consumer == nil && p.getPauseState() != subscriberStateDrained {
+		consumer, err := p.newConsumer()
+		if err != nil {
+			p.logger.Info("notifier state changed error", tag.LifeCycleStartFailed, tag.Error(err))
+			return fmt.Errorf("failed to create consumer: %v", err)
+		}
+		p.consumer = consumer
+	}
 
+	if p.dedup != nil {
+		// loaded on start rather than on creation, as a reloaded notifier is created before the old one saves
+		if err := p.dedup.load(); err != nil {
+			p.logger.Warn("failed to load dedup state, starting with an empty dedup window", tag.Error(err))
+		}
+		p.shutdownWG.Add(1)
+		go p.dedupSaveLoop()
+	}
 	p.shutdownWG.Add(1)
 	go p.processorPump()
+	atomic.StoreInt32(&p.isConsumerStarted, 1)
 
 	p.logger.Info("notifier state changed", tag.LifeCycleStarted)
 	return nil
@@ -120,6 +288,7 @@ func (p *notifier) Stop() {
 	p.logger.Info("notifier state changed", tag.LifeCycleStopping)
 	defer p.logger.Info("notifier state changed", tag.LifeCycleStopped)
 
+	p.shutdownCancel()
 	if atomic.LoadInt32(&p.isStarted) == 1 {
 		close(p.shutdownCh)
 	}
@@ -127,56 +296,184 @@ func (p *notifier) Stop() {
 	if success := common.AwaitWaitGroup(&p.shutdownWG, time.Minute); !success {
 		p.logger.Info("notifier state changed error", tag.LifeCycleStopTimedout)
 	}
+	p.sink.Stop()
+	if p.dedup != nil {
+		if err := p.dedup.save(); err != nil {
+			p.logger.Warn("failed to save dedup state", tag.Error(err))
+		}
+	}
+	if p.dlqPublisher != nil {
+		if err := p.dlqPublisher.close(); err != nil {
+			p.logger.Warn("failed to close DLQ producer", tag.Error(err))
+		}
+	}
 }
 
+func (p *notifier) dedupSaveLoop() {
+	defer p.shutdownWG.Done()
+
+	ticker := time.NewTicker(dedupSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.shutdownCh:
+			return
+		case <-ticker.C:
+			if err := p.dedup.save(); err != nil {
+				p.logger.Warn("failed to save dedup state", tag.Error(err))
+			}
+		}
+	}
+}
+
+// processorPump runs the consumer until the notifier is stopped. A drain stops the consumer,
+// so that its partitions are taken over by the other instances of the consumer group, resuming starts a new one.
 func (p *notifier) processorPump() {
 	defer p.shutdownWG.Done()
 
-	var workerWG sync.WaitGroup
 	concurrency := defaultConcurrency
 	if p.consumerConfig.Concurrency > 0 {
 		concurrency = p.consumerConfig.Concurrency
 	}
+	if hinter, ok := p.sink.(concurrencyHinter); ok && hinter.minConcurrency() > concurrency {
+		p.logger.Info(fmt.Sprintf("raising concurrency from %v to %v as required by the sink", concurrency, hinter.minConcurrency()))
+		concurrency = hinter.minConcurrency()
+	}
 
-	for workerID := 0; workerID < concurrency; workerID++ {
+	for {
+		if p.getPauseState() == subscriberStateDrained && !p.waitUntilResumed(p.shutdownCh) {
+			return
+		}
+		if p.consumer == nil {
+			consumer, err := p.newConsumer()
+			if err != nil {
+				p.logger.Error("failed to create consumer", tag.Error(err))
+				p.recordError(err)
+				select {
+				case <-p.shutdownCh:
+					return
+				case <-time.After(consumerRestartInterval):
+				}
+				continue
+			}
+			p.consumer = consumer
+		}
+		if err := p.consumer.Start(); err != nil {
+			p.logger.Error("failed to start consumer", tag.Error(err))
+			p.recordError(err)
+			select {
+			case <-p.shutdownCh:
+				return
+			case <-time.After(consumerRestartInterval):
+			}
+			continue
+		}
+
+		stopped := p.runConsumer(p.consumer, concurrency)
+		p.consumer = nil
+		if stopped {
+			return
+		}
+	}
+}
+
+// runConsumer processes the messages of the started consumer until the notifier is stopped or drained.
+// It stops the consumer and returns true if the notifier is stopped.
+func (p *notifier) runConsumer(consumer messaging.Consumer, concurrency int) bool {
+	var workerWG sync.WaitGroup
+	// closed to stop the workers of this consumer
+	stopCh := make(chan struct{})
+	if p.consumerConfig.Ordering != "" {
+		queues := newKeyedQueues(concurrency * orderedPendingPerWorker)
+		for workerID := 0; workerID < concurrency; workerID++ {
+			workerWG.Add(1)
+			go p.orderedProcessLoop(&workerWG, stopCh, queues)
+		}
 		workerWG.Add(1)
-		go p.messageProcessLoop(&workerWG)
+		go p.orderedDispatchLoop(&workerWG, consumer, stopCh, queues)
+	} else {
+		for workerID := 0; workerID < concurrency; workerID++ {
+			workerWG.Add(1)
+			go p.messageProcessLoop(&workerWG, consumer, stopCh)
+		}
 	}
 
-	<-p.shutdownCh
-	// Processor is shutting down, close the underlying consumer
-	p.consumer.Stop()
+	stopped := false
+	select {
+	case <-p.shutdownCh:
+		stopped = true
+		p.logger.Info("notifier pump shutting down.")
+	case <-p.drainedC:
+		p.logger.Info("notifier drained, stopping the consumer")
+	}
+	close(stopCh)
+	stopConsumer(consumer)
 
-	p.logger.Info("notifier pump shutting down.")
 	if success := common.AwaitWaitGroup(&workerWG, 10*time.Second); !success {
 		p.logger.Warn("notifier timed out on worker shutdown.")
 	}
+	return stopped
+}
+
+// stopConsumer discards the messages still coming from the consumer while stopping it. They are not acked,
+// so they are consumed again later. Otherwise a fetch blocked on the full Messages channel, e.g. while paused,
+// panics when Stop closes the channel.
+func stopConsumer(consumer messaging.Consumer) {
+	discarded := make(chan struct{})
+	go func() {
+		defer close(discarded)
+		for range consumer.Messages() {
+		}
+	}()
+	consumer.Stop()
+	<-discarded
 }
 
-func (p *notifier) messageProcessLoop(workerWG *sync.WaitGroup) {
+func (p *notifier) messageProcessLoop(workerWG *sync.WaitGroup, consumer messaging.Consumer, stopCh <-chan struct{}) {
 	defer workerWG.Done()
 
-	for msg := range p.consumer.Messages() {
+	for p.waitUntilResumed(stopCh) {
+		var msg messaging.Message
+		select {
+		case m, ok := <-consumer.Messages():
+			if !ok {
+				return
+			}
+			msg = m
+		case <-stopCh:
+			return
+		}
+		// a message received right before pausing is held until resumed
+		if !p.beginDelivery(stopCh) {
+			return
+		}
 		sw := p.metricScope.Timer(processLatency).Start()
 		err := p.process(msg)
 		sw.Stop()
-		if err != nil {
+		p.endDelivery()
+		if err != nil && err != errNotifierStopped {
 			_ = msg.Nack()
 		}
 	}
 }
 
 func (p *notifier) process(kafkaMsg messaging.Message) error {
-	logger := p.logger.WithTags(tag.KafkaPartition(kafkaMsg.Partition()), tag.KafkaOffset(kafkaMsg.Offset()), tag.AttemptStart(time.Now()))
+	decodedMsg, err := p.decode(kafkaMsg)
+	if err != nil {
+		return err
+	}
+	return p.notifySubscriber(decodedMsg, kafkaMsg)
+}
 
+func (p *notifier) decode(kafkaMsg messaging.Message) (*indexer.Message, error) {
 	decodedMsg, err := p.deserialize(kafkaMsg.Value())
 	if err != nil {
+		logger := p.logger.WithTags(tag.KafkaPartition(kafkaMsg.Partition()), tag.KafkaOffset(kafkaMsg.Offset()), tag.AttemptStart(time.Now()))
 		logger.Error("Failed to deserialize index messages.", tag.Error(err))
-		p.metricScope.Counter(corruptedData)
-		return err
+		p.metricScope.Counter(corruptedData).Inc(1)
+		return nil, err
 	}
-
-	return p.notifySubscriber(decodedMsg, kafkaMsg, &p.subscriberConfig.Delivery.Webhook)
+	return decodedMsg, nil
 }
 
 func (p *notifier) deserialize(payload []byte) (*indexer.Message, error) {
@@ -187,42 +484,214 @@ func (p *notifier) deserialize(payload []byte) (*indexer.Message, error) {
 	return &msg, nil
 }
 
-func (p *notifier) notifySubscriber(decodedMsg *indexer.Message, kafkaMsg messaging.Message, webhook *config.Webhook) error {
-
+func (p *notifier) notifySubscriber(decodedMsg *indexer.Message, kafkaMsg messaging.Message) error {
 	switch decodedMsg.GetMessageType() {
 	case indexer.MessageTypeIndex:
+		if !p.isDomainSelected(decodedMsg.GetDomainID()) {
+			p.metricScope.Counter(filteredMessages).Inc(1)
+			_ = kafkaMsg.Ack()
+			return nil
+		}
+
 		id := fmt.Sprintf("%v-%v", kafkaMsg.Partition(), kafkaMsg.Offset())
 		notification, err := p.generateNotification(decodedMsg, id)
 		if err != nil {
-			_ = kafkaMsg.Nack()
+			return err
+		}
+		if p.filterExpression != nil && !p.filterExpression.Match(notification) {
+			p.metricScope.Counter(filteredMessages).Inc(1)
+			_ = kafkaMsg.Ack()
+			return nil
+		}
+
+		if p.dedup != nil && p.dedup.seen(notification.IdempotencyKey) {
+			p.metricScope.Counter(duplicatesSuppressed).Inc(1)
+			_ = kafkaMsg.Ack()
+			return nil
 		}
 
-		backoff.NewThrottleRetry().Do(
-			context.Background(),
-			func() error { return p.sendMessageToWebhook(notification, webhook) },
-		)
+		ctx := withDeliveryInfo(p.shutdownCtx, &DeliveryInfo{
+			Subscriber:      p.subscriberConfig.Name,
+			SourcePartition: kafkaMsg.Partition(),
+			SourceOffset:    kafkaMsg.Offset(),
+		})
+		// waiting in deliver holds back the worker, so that an open circuit or throttling stops or slows down consuming
+		err = p.deliver(ctx, notification)
+		if err != nil && p.shutdownCtx.Err() != nil {
+			return errNotifierStopped
+		}
+		if err == nil {
+			atomic.StoreInt64(&p.lastSuccessTime, time.Now().UnixNano())
+			if p.dedup != nil {
+				p.dedup.add(notification.IdempotencyKey)
+			}
+		} else {
+			p.recordError(err)
+			p.metricScope.Counter(deliveryFailures).Inc(1)
+			// only ack after the message is safely in the DLQ, otherwise it's nacked to the application DLQ
+			if err := p.sendToDLQ(decodedMsg, kafkaMsg, err); err != nil {
+				return err
+			}
+		}
 		_ = kafkaMsg.Ack()
 	case indexer.MessageTypeDelete:
 		// this is when workflow run passes retention, noop for now
 		_ = kafkaMsg.Ack()
 	default:
 		p.logger.Error("Unknown message type")
-		p.metricScope.Counter(corruptedData)
+		p.metricScope.Counter(corruptedData).Inc(1)
 		return errUnknownMessageType
 	}
 
 	return nil
 }
 
+// deliver sends the notification through the sink within the delivery limits and the circuit breaker,
+// which is passed to the sink if it records every attempt, see attemptBreakingSink.
+// It blocks while the circuit is open or the delivery is throttled, and returns the ctx error if ctx is done meanwhile.
+func (p *notifier) deliver(ctx context.Context, notification *Notification) error {
+	trial := false
+	sink, ok := p.sink.(attemptBreakingSink)
+	breaksPerAttempt := ok && sink.breaksPerAttempt()
+	if p.breaker != nil && breaksPerAttempt {
+		ctx = withCircuitBreaker(ctx, p.breaker)
+	} else if p.breaker != nil {
+		var err error
+		if trial, err = p.breaker.allow(ctx); err != nil {
+			return err
+		}
+	}
+	if !p.limitsPerRequest {
+		throttled, err := p.limiter.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		if throttled {
+			p.metricScope.Counter(throttledDeliveries).Inc(1)
+		}
+		defer p.limiter.release()
+	}
+	err := p.sink.Deliver(ctx, notification)
+	if p.breaker != nil && !breaksPerAttempt {
+		p.breaker.recordResult(ctx, trial, err, false)
+	}
+	return err
+}
+
+func (p *notifier) sendToDLQ(msg *indexer.Message, kafkaMsg messaging.Message, deliveryErr error) error {
+	attempts, statusCode := 1, 0
+	var err *DeliveryError
+	if errors.As(deliveryErr, &err) {
+		attempts, statusCode = err.Attempts, err.StatusCode
+	}
+	logger := p.logger.WithTags(
+		tag.KafkaPartition(kafkaMsg.Partition()),
+		tag.KafkaOffset(kafkaMsg.Offset()),
+		tag.WorkflowID(msg.GetWorkflowID()),
+		tag.WorkflowRunID(msg.GetRunID()),
+		tag.Attempt(int32(attempts)),
+	)
+	if p.dlqPublisher == nil {
+		logger.Error("Failed to deliver notification, no consumerGroupDlqTopic configured", tag.Error(deliveryErr))
+		return deliveryErr
+	}
+
+	publishErr := p.dlqPublisher.publish(&dlqEntry{
+		key:             msg.GetWorkflowID(),
+		payload:         kafkaMsg.Value(),
+		subscriber:      p.subscriberConfig.Name,
+		reason:          deliveryErr.Error(),
+		attempts:        attempts,
+		lastStatusCode:  statusCode,
+		sourcePartition: kafkaMsg.Partition(),
+		sourceOffset:    kafkaMsg.Offset(),
+	})
+	if publishErr != nil {
+		p.metricScope.Counter(dlqPublishFailures).Inc(1)
+		logger.Error("Failed to publish notification to DLQ", tag.Error(publishErr))
+		return publishErr
+	}
+	p.metricScope.Counter(dlqMessages).Inc(1)
+	logger.Warn("Failed to deliver notification, published to DLQ", tag.Error(deliveryErr))
+	return nil
+}
+
+func (p *notifier) recordError(err error) {
+	p.lastErrorLock.Lock()
+	defer p.lastErrorLock.Unlock()
+	p.lastError = err.Error()
+	p.lastErrorTime = time.Now()
+}
+
+// status returns the state of the notifier for the admin server
+func (p *notifier) status() *subscriberStatus {
+	status := &subscriberStatus{
+		Name:     p.subscriberConfig.Name,
+		State:    subscriberStateInitialized,
+		InFlight: atomic.LoadInt64(&p.inFlight),
+		Limits:   p.limiter.getLimits(),
+	}
+	if p.breaker != nil {
+		status.CircuitBreaker = p.breaker.getState()
+	}
+	switch {
+	case atomic.LoadInt32(&p.isStopped) == 1:
+		status.State = subscriberStateStopped
+	case atomic.LoadInt32(&p.isConsumerStarted) == 1:
+		status.State = subscriberStateStarted
+		if pauseState := p.getPauseState(); pauseState != "" {
+			status.State = pauseState
+		}
+	case atomic.LoadInt32(&p.isStarted) == 1:
+		status.State = subscriberStateStarting
+	}
+	if lastSuccessTime := atomic.LoadInt64(&p.lastSuccessTime); lastSuccessTime != 0 {
+		t := time.Unix(0, lastSuccessTime)
+		status.LastSuccessTime = &t
+	}
+
+	p.lastErrorLock.Lock()
+	defer p.lastErrorLock.Unlock()
+	if p.lastError != "" {
+		t := p.lastErrorTime
+		status.LastError = p.lastError
+		status.LastErrorTime = &t
+	}
+	return status
+}
+
+// isDomainSelected returns true if the subscriber should be notified about workflows of the domain.
+// Messages of unknown domains are filtered out rather than retried, as a deleted domain would block the partition
+// forever. They're counted by unknownDomains as well as filteredMessages
+func (p *notifier) isDomainSelected(domainID string) bool {
+	if len(p.selectedDomains) == 0 {
+		return true
+	}
+	// the resolver has refreshed its mapping before reporting an unknown domain
+	domainName, ok := p.domainResolver.GetDomainName(domainID)
+	if !ok {
+		p.metricScope.Counter(unknownDomains).Inc(1)
+		p.logger.Warn("Domain not found in the domain mapping, filtering out the message", tag.WorkflowDomainID(domainID))
+		return false
+	}
+	_, ok = p.selectedDomains[domainName]
+	return ok
+}
+
 func (p *notifier) generateNotification(msg *indexer.Message, id string) (*Notification, error) {
 	searchAttrs, memo, err := p.dumpAllFieldsToMap(msg.Fields)
 	if err != nil {
 		return nil, err
 	}
+	if p.decodeMemo {
+		memo = p.decodeMemoFields(memo, searchAttrs)
+	}
 
 	notification := &Notification{
+		SchemaVersion:    NotificationSchemaVersion,
 		ID:               id,
 		DomainID:         msg.GetDomainID(),
+		DomainName:       p.getDomainName(msg.GetDomainID()),
 		WorkflowID:       msg.GetWorkflowID(),
 		RunID:            msg.GetRunID(),
 		SearchAttributes: searchAttrs,
@@ -251,6 +720,21 @@ func (p *notifier) generateNotification(msg *indexer.Message, id string) (*Notif
 		executionTime = time.Unix(0, searchAttrs[es.ExecutionTime].(int64))
 		notification.ExecutionTimestamp = &executionTime
 	}
+	if closeStatus, ok := searchAttrs[es.CloseStatus].(int64); ok && notification.ClosedTimestamp != nil {
+		notification.CloseStatus = types.WorkflowExecutionCloseStatus(closeStatus).String()
+	}
+	notification.HistoryLength, _ = searchAttrs[es.HistoryLength].(int64)
+	notification.TaskList, _ = searchAttrs[es.TaskList].(string)
+	notification.IsCron, _ = searchAttrs[es.IsCron].(bool)
+	notification.NumClusters, _ = searchAttrs[es.NumClusters].(int64)
+	if notification.StartedTimestamp != nil && notification.ClosedTimestamp != nil {
+		duration := closeTime.Sub(startTime)
+		notification.Duration = &duration
+	}
+	if notification.StartedTimestamp != nil && notification.ExecutionTimestamp != nil {
+		queueDelay := executionTime.Sub(startTime)
+		notification.QueueDelay = &queueDelay
+	}
 
 	if msg.VisibilityOperation != nil {
 		switch *msg.VisibilityOperation {
@@ -265,9 +749,29 @@ func (p *notifier) generateNotification(msg *indexer.Message, id string) (*Notif
 		}
 	}
 
+	// without its event time, an event falls back to the version, and then to the position of the message
+	event := strconv.FormatInt(msg.GetVersion(), 10)
+	switch {
+	case notification.VisibilityOperation == common.RecordStarted && notification.StartedTimestamp != nil:
+		event = strconv.FormatInt(startTime.UnixNano(), 10)
+	case notification.VisibilityOperation == common.RecordClosed && notification.ClosedTimestamp != nil:
+		event = strconv.FormatInt(closeTime.UnixNano(), 10)
+	case msg.GetVersion() == 0:
+		event = id
+	}
+	notification.IdempotencyKey = newIdempotencyKey(notification.DomainID, notification.RunID, notification.VisibilityOperation, event)
+
 	return notification, nil
 }
 
+func (p *notifier) getDomainName(domainID string) string {
+	if p.domainResolver == nil {
+		return ""
+	}
+	domainName, _ := p.domainResolver.GetDomainName(domainID)
+	return domainName
+}
+
 // return search attributes, memo, and error
 func (p *notifier) dumpAllFieldsToMap(fields map[string]*indexer.Field) (map[string]interface{}, map[string]interface{}, error) {
 	sa := make(map[string]interface{})
@@ -300,39 +804,39 @@ func (p *notifier) decodeSearchAttrBinary(bytes []byte, key string) interface{}
 	err := json.Unmarshal(bytes, &val)
 	if err != nil {
 		p.logger.Error("Error when decode search attributes values.", tag.Error(err), tag.ESField(key))
-		p.metricScope.Counter(corruptedData)
+		p.metricScope.Counter(corruptedData).Inc(1)
 	}
 	return val
 }
 
-func (p *notifier) sendMessageToWebhook(notification *Notification, webhook *config.Webhook) error {
-	jsonBytes, err := json.Marshal(notification)
-	if err != nil {
-		p.logger.Error(err.Error())
-		return err
+// decodeMemoFields decodes the serialized memo into a key -> value map.
+// The raw memo is returned if it can't be decoded.
+func (p *notifier) decodeMemoFields(rawMemo map[string]interface{}, searchAttrs map[string]interface{}) map[string]interface{} {
+	data, ok := rawMemo[definition.Memo].([]byte)
+	if !ok || len(data) == 0 {
+		return rawMemo
 	}
-	req, err := http.NewRequest("POST", webhook.URL.String(), bytes.NewBuffer(jsonBytes))
-	if err != nil {
-		return err
+	encoding := common.EncodingTypeThriftRW
+	if e, ok := searchAttrs[definition.Encoding].(string); ok && e != "" {
+		encoding = common.EncodingType(e)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	// TODO: setup retry logic using webhook config
-	p.logger.Debug("sending http request")
-	resp, err := p.httpClient.Do(req)
+	memo, err := p.payloadSerializer.DeserializeVisibilityMemo(persistence.NewDataBlob(data, encoding))
 	if err != nil {
-		p.logger.Error(err.Error())
-		return err
+		p.logger.Error("Error when decode memo.", tag.Error(err))
+		p.metricScope.Counter(corruptedData).Inc(1)
+		return rawMemo
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP request failed")
+	decoded := make(map[string]interface{}, len(memo.GetFields()))
+	for k, v := range memo.GetFields() {
+		var val interface{}
+		if err := json.Unmarshal(v, &val); err != nil {
+			// json.Marshal encodes []byte as base64 string
+			decoded[k] = v
+			continue
+		}
+		decoded[k] = val
 	}
-
-	p.logger.Debug(fmt.Sprintf("response Status: %v", resp.Status))
-	p.logger.Debug(fmt.Sprintf("response Headers: %v", resp.Header))
-	body, _ := ioutil.ReadAll(resp.Body)
-	p.logger.Debug(fmt.Sprintf("response Body: %v", string(body)))
-	return nil
+	return decoded
 }
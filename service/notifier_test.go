@@ -0,0 +1,392 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/uber-go/tally"
+	"github.com/uber/cadence/.gen/go/indexer"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/codec"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/definition"
+	es "github.com/uber/cadence/common/elasticsearch"
+	"github.com/uber/cadence/common/log/loggerimpl"
+	"github.com/uber/cadence/common/messaging"
+	"github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/types"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+type (
+	// fakeConsumer keeps fetching messages into a small buffer until stopped, like the Cadence consumer.
+	// The Cadence consumer closes the channel right after canceling the fetch, which panics a fetch blocked
+	// on a full channel. This one waits for the fetch instead, which turns the panic into a detectable hang.
+	fakeConsumer struct {
+		payload   []byte
+		messages  chan messaging.Message
+		ctx       context.Context
+		cancel    context.CancelFunc
+		fetchDone chan struct{}
+		acked     int64
+	}
+
+	fakeMessage struct {
+		consumer *fakeConsumer
+		offset   int64
+	}
+
+	// fakeSink counts the deliveries, each one taking delay
+	fakeSink struct {
+		delay     time.Duration
+		delivered int64
+	}
+)
+
+func newFakeConsumer(t *testing.T) *fakeConsumer {
+	payload, err := codec.NewThriftRWEncoder().Encode(&indexer.Message{
+		MessageType: indexer.MessageTypeIndex.Ptr(),
+		DomainID:    stringPtr("domain-id"),
+		WorkflowID:  stringPtr("workflow-id"),
+		RunID:       stringPtr("run-id"),
+		Version:     int64Ptr(1),
+		Fields:      map[string]*indexer.Field{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &fakeConsumer{
+		payload:   payload,
+		messages:  make(chan messaging.Message, 16),
+		ctx:       ctx,
+		cancel:    cancel,
+		fetchDone: make(chan struct{}),
+	}
+}
+
+func (c *fakeConsumer) Start() error {
+	go func() {
+		defer close(c.fetchDone)
+		for offset := int64(0); c.ctx.Err() == nil; offset++ {
+			c.messages <- &fakeMessage{consumer: c, offset: offset}
+		}
+	}()
+	return nil
+}
+
+func (c *fakeConsumer) Stop() {
+	c.cancel()
+	<-c.fetchDone
+	close(c.messages)
+}
+
+func (c *fakeConsumer) Messages() <-chan messaging.Message {
+	return c.messages
+}
+
+func (c *fakeConsumer) isStopped() bool {
+	select {
+	case <-c.fetchDone:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *fakeMessage) Value() []byte    { return m.consumer.payload }
+func (m *fakeMessage) Partition() int32 { return 0 }
+func (m *fakeMessage) Offset() int64    { return m.offset }
+func (m *fakeMessage) Nack() error      { return nil }
+
+func (m *fakeMessage) Ack() error {
+	atomic.AddInt64(&m.consumer.acked, 1)
+	return nil
+}
+
+func (s *fakeSink) Start() error { return nil }
+func (s *fakeSink) Stop()        {}
+
+func (s *fakeSink) Deliver(ctx context.Context, _ *Notification) error {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	atomic.AddInt64(&s.delivered, 1)
+	return nil
+}
+
+func (s *fakeSink) getDelivered() int64 {
+	return atomic.LoadInt64(&s.delivered)
+}
+
+func stringPtr(s string) *string { return &s }
+func int64Ptr(i int64) *int64    { return &i }
+
+// newTestNotifier returns a started notifier consuming from fake consumers, and the consumers created so far
+func newTestNotifier(t *testing.T, sink Sink) (*notifier, func() []*fakeConsumer) {
+	subscriber := &config.Subscriber{Name: "test"}
+	subscriber.Consumer.Concurrency = 4
+	// nothing to seed in Kafka
+	subscriber.Consumer.InitialOffset = initialOffsetOldest
+	p, err := newNotifierWithSink(&cconfig.KafkaConfig{}, subscriber, nil, sink, loggerimpl.NewNopLogger(), tally.NoopScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lock sync.Mutex
+	var consumers []*fakeConsumer
+	p.newConsumer = func() (messaging.Consumer, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		consumer := newFakeConsumer(t)
+		consumers = append(consumers, consumer)
+		return consumer, nil
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return p, func() []*fakeConsumer {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]*fakeConsumer{}, consumers...)
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func stopWithTimeout(t *testing.T, p *notifier) {
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out stopping the notifier")
+	}
+}
+
+func TestNotifierStopWhilePaused(t *testing.T) {
+	sink := &fakeSink{}
+	p, consumers := newTestNotifier(t, sink)
+	waitFor(t, "deliveries", func() bool { return sink.getDelivered() > 0 })
+
+	p.pause()
+	consumer := consumers()[0]
+	waitFor(t, "the fetch to block on the full channel", func() bool { return len(consumer.messages) == cap(consumer.messages) })
+	delivered := sink.getDelivered()
+	time.Sleep(50 * time.Millisecond)
+	if sink.getDelivered() != delivered {
+		t.Error("expected no deliveries while paused")
+	}
+
+	stopWithTimeout(t, p)
+	if !consumer.isStopped() {
+		t.Error("expected the consumer to be stopped")
+	}
+}
+
+func TestNotifierDrainAndResume(t *testing.T) {
+	sink := &fakeSink{delay: 10 * time.Millisecond}
+	p, consumers := newTestNotifier(t, sink)
+	waitFor(t, "deliveries", func() bool { return sink.getDelivered() > 0 })
+
+	p.drain()
+	waitFor(t, "drained", func() bool { return p.getPauseState() == subscriberStateDrained })
+	if inFlight := atomic.LoadInt64(&p.inFlight); inFlight != 0 {
+		t.Errorf("expected no deliveries in flight once drained, got %v", inFlight)
+	}
+	first := consumers()[0]
+	waitFor(t, "the consumer to be stopped", first.isStopped)
+	// every delivery started before draining is finished and acked
+	if acked := atomic.LoadInt64(&first.acked); acked != sink.getDelivered() {
+		t.Errorf("expected %v acked messages, got %v", sink.getDelivered(), acked)
+	}
+
+	delivered := sink.getDelivered()
+	p.resume()
+	waitFor(t, "a new consumer", func() bool { return len(consumers()) == 2 })
+	waitFor(t, "deliveries after resuming", func() bool { return sink.getDelivered() > delivered })
+
+	stopWithTimeout(t, p)
+	if !consumers()[1].isStopped() {
+		t.Error("expected the new consumer to be stopped")
+	}
+}
+
+func TestNotifierStopWhileDrained(t *testing.T) {
+	sink := &fakeSink{}
+	p, consumers := newTestNotifier(t, sink)
+	waitFor(t, "deliveries", func() bool { return sink.getDelivered() > 0 })
+
+	p.drain()
+	waitFor(t, "the consumer to be stopped", consumers()[0].isStopped)
+	stopWithTimeout(t, p)
+	if n := len(consumers()); n != 1 {
+		t.Errorf("expected no new consumer, got %v consumers", n)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	p, err := newNotifierWithSink(&cconfig.KafkaConfig{}, &config.Subscriber{Name: "test"}, nil, &fakeSink{}, loggerimpl.NewNopLogger(), tally.NoopScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := indexer.VisibilityOperationRecordClosed
+	newMessage := func(version int64, closeTime int64) *indexer.Message {
+		fields := map[string]*indexer.Field{}
+		if closeTime != 0 {
+			fields[es.CloseTime] = &indexer.Field{Type: indexer.FieldTypeInt.Ptr(), IntData: int64Ptr(closeTime)}
+		}
+		return &indexer.Message{
+			MessageType:         indexer.MessageTypeIndex.Ptr(),
+			DomainID:            stringPtr("domain-id"),
+			WorkflowID:          stringPtr("workflow-id"),
+			RunID:               stringPtr("run-id"),
+			Version:             int64Ptr(version),
+			VisibilityOperation: &closed,
+			Fields:              fields,
+		}
+	}
+	getKey := func(msg *indexer.Message, id string) string {
+		notification, err := p.generateNotification(msg, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return notification.IdempotencyKey
+	}
+
+	// re-emitted records of the same close have different versions
+	if getKey(newMessage(1, 100), "0-1") != getKey(newMessage(2, 100), "0-2") {
+		t.Error("expected the same key for the same close time")
+	}
+	if getKey(newMessage(1, 100), "0-1") == getKey(newMessage(1, 200), "0-1") {
+		t.Error("expected different keys for different close times")
+	}
+	// without a close time, the version tells the events apart
+	if getKey(newMessage(1, 0), "0-1") != getKey(newMessage(1, 0), "0-2") {
+		t.Error("expected the same key for the same version without a close time")
+	}
+	if getKey(newMessage(1, 0), "0-1") == getKey(newMessage(2, 0), "0-1") {
+		t.Error("expected different keys for different versions without a close time")
+	}
+	// without a version either, the position of the message does
+	if getKey(newMessage(0, 0), "0-1") != getKey(newMessage(0, 0), "0-1") {
+		t.Error("expected the same key for the same message")
+	}
+	if getKey(newMessage(0, 0), "0-1") == getKey(newMessage(0, 0), "0-2") {
+		t.Error("expected different keys for different messages without a close time or version")
+	}
+}
+
+func TestMemoEncoding(t *testing.T) {
+	memo, err := persistence.NewPayloadSerializer().SerializeVisibilityMemo(&types.Memo{Fields: map[string][]byte{
+		"owner": []byte(`"payments"`),
+		"count": []byte(`3`),
+		"raw":   []byte{0xff, 0x00},
+	}}, common.EncodingTypeThriftRW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newMessage := func(memo []byte) *indexer.Message {
+		return &indexer.Message{
+			MessageType: indexer.MessageTypeIndex.Ptr(),
+			DomainID:    stringPtr("domain-id"),
+			WorkflowID:  stringPtr("workflow-id"),
+			RunID:       stringPtr("run-id"),
+			Version:     int64Ptr(1),
+			Fields: map[string]*indexer.Field{
+				definition.Memo:     {Type: indexer.FieldTypeBinary.Ptr(), BinaryData: memo},
+				definition.Encoding: {Type: indexer.FieldTypeString.Ptr(), StringData: stringPtr(string(common.EncodingTypeThriftRW))},
+			},
+		}
+	}
+	tests := []struct {
+		name         string
+		memoEncoding string
+		memo         []byte
+		expected     map[string]interface{}
+		corrupted    int64
+	}{
+		{
+			name:         "raw",
+			memoEncoding: memoEncodingRaw,
+			memo:         memo.Data,
+			expected:     map[string]interface{}{definition.Memo: memo.Data},
+		},
+		{
+			name:         "json",
+			memoEncoding: memoEncodingJSON,
+			memo:         memo.Data,
+			expected:     map[string]interface{}{"owner": "payments", "count": float64(3), "raw": []byte{0xff, 0x00}},
+		},
+		{
+			name:         "json corrupted",
+			memoEncoding: memoEncodingJSON,
+			memo:         []byte{0x01, 0x02, 0x03},
+			expected:     map[string]interface{}{definition.Memo: []byte{0x01, 0x02, 0x03}},
+			corrupted:    1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscriber := &config.Subscriber{Name: "test", MemoEncoding: test.memoEncoding}
+			scope := tally.NewTestScope("", nil)
+			p, err := newNotifierWithSink(&cconfig.KafkaConfig{}, subscriber, nil, &fakeSink{}, loggerimpl.NewNopLogger(), scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notification, err := p.generateNotification(newMessage(test.memo), "0-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(notification.Memo, test.expected) {
+				t.Errorf("expected memo %v, got %v", test.expected, notification.Memo)
+			}
+			var corrupted int64
+			for _, counter := range scope.Snapshot().Counters() {
+				if counter.Name() == corruptedData {
+					corrupted += counter.Value()
+				}
+			}
+			if corrupted != test.corrupted {
+				t.Errorf("expected %v corrupted data, got %v", test.corrupted, corrupted)
+			}
+		})
+	}
+}
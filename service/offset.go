@@ -0,0 +1,129 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+)
+
+// parseInitialOffset returns the sarama offset or the timestamp in milliseconds to start from.
+// ok is false if the Cadence Kafka client default(oldest) should be used.
+func parseInitialOffset(initialOffset string) (offset int64, ok bool, err error) {
+	switch initialOffset {
+	case "", initialOffsetOldest:
+		return 0, false, nil
+	case initialOffsetNewest:
+		return sarama.OffsetNewest, true, nil
+	default:
+		t, err := time.Parse(time.RFC3339, initialOffset)
+		if err != nil {
+			return 0, false, fmt.Errorf("unknown consumer.initialOffset %q, supported values: %v, %v or a RFC3339 timestamp",
+				initialOffset, initialOffsetNewest, initialOffsetOldest)
+		}
+		return t.UnixNano() / int64(time.Millisecond), true, nil
+	}
+}
+
+// seedInitialOffsets commits the starting offsets of the consumer group according to initialOffset,
+// for the partitions that have no committed offset yet, i.e. when the consumer group consumes for the first time.
+// It's needed because the Cadence Kafka consumer always starts from the oldest offset.
+// An empty initialOffset only warns about the implicit oldest, as replaying the whole topic is rarely intended.
+func seedInitialOffsets(kafkaConfig *cconfig.KafkaConfig, topic string, group string, initialOffset string, logger log.Logger) (retErr error) {
+	target, ok, err := parseInitialOffset(initialOffset)
+	if err != nil || (!ok && initialOffset != "") {
+		return err
+	}
+
+	brokers, err := getBrokersForTopic(kafkaConfig, topic)
+	if err != nil {
+		return err
+	}
+	saramaConfig, err := newSaramaConfig(kafkaConfig)
+	if err != nil {
+		return err
+	}
+	// NextOffset returns this when there is no committed offset
+	saramaConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
+	saramaConfig.Consumer.Return.Errors = true
+	client, err := sarama.NewClient(brokers, saramaConfig)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	offsetManager, err := sarama.NewOffsetManagerFromClient(group, client)
+	if err != nil {
+		return err
+	}
+	var partitionManagers []sarama.PartitionOffsetManager
+	defer func() {
+		// the partition offset managers have to be closed before the offset manager, which flushes the marked offsets
+		for _, pom := range partitionManagers {
+			if err := pom.Close(); err != nil && retErr == nil {
+				retErr = fmt.Errorf("failed to commit initial offsets: %v", err)
+			}
+		}
+		if err := offsetManager.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	for _, partition := range partitions {
+		pom, err := offsetManager.ManagePartition(topic, partition)
+		if err != nil {
+			return err
+		}
+		partitionManagers = append(partitionManagers, pom)
+		if committed, _ := pom.NextOffset(); committed >= 0 {
+			continue
+		}
+		if !ok {
+			logger.Warn("consumer group has no committed offset and consumer.initialOffset is not set, consuming from the oldest offset",
+				tag.KafkaTopicName(topic), tag.KafkaConsumerName(group))
+			return nil
+		}
+
+		offset, err := client.GetOffset(topic, partition, target)
+		if err != nil {
+			return err
+		}
+		if offset < 0 {
+			// no message after the timestamp
+			if offset, err = client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+				return err
+			}
+		}
+		pom.MarkOffset(offset, "")
+		logger.Info("seeded initial offset of consumer group",
+			tag.KafkaTopicName(topic), tag.KafkaPartition(partition), tag.KafkaOffset(offset), tag.Value(initialOffset))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"sync"
+
+	"github.com/uber/cadence/.gen/go/indexer"
+	"github.com/uber/cadence/common/messaging"
+)
+
+const (
+	orderingWorkflowID = "workflowID"
+	orderingRunID      = "runID"
+
+	// max messages waiting in the per-key queues per worker, to bound the memory in ordered mode
+	orderedPendingPerWorker = 100
+)
+
+type (
+	// orderedTask is a decoded message waiting for delivery in ordered mode
+	orderedTask struct {
+		kafkaMsg   messaging.Message
+		decodedMsg *indexer.Message
+	}
+
+	// keyedQueues keeps a FIFO queue per ordering key. A key is handed to at most one worker at a time,
+	// so messages of the same key are processed in order, while a slow key only holds up its own queue.
+	keyedQueues struct {
+		sync.Mutex
+		queues map[string][]*orderedTask
+		// keys with pending tasks that are not being processed by any worker
+		ready chan string
+		// limits the number of pending tasks, and so the number of keys in ready
+		pending chan struct{}
+	}
+)
+
+func newKeyedQueues(maxPending int) *keyedQueues {
+	return &keyedQueues{
+		queues:  make(map[string][]*orderedTask),
+		ready:   make(chan string, maxPending),
+		pending: make(chan struct{}, maxPending),
+	}
+}
+
+// push appends the task to the queue of the key, the caller must have acquired a pending slot
+func (q *keyedQueues) push(key string, task *orderedTask) {
+	q.Lock()
+	queue, active := q.queues[key]
+	q.queues[key] = append(queue, task)
+	q.Unlock()
+	if !active {
+		q.ready <- key
+	}
+}
+
+// peek returns the head of the queue of a key taken from ready
+func (q *keyedQueues) peek(key string) *orderedTask {
+	q.Lock()
+	defer q.Unlock()
+	return q.queues[key][0]
+}
+
+// done removes the head of the queue, and reschedules the key if it has more tasks
+func (q *keyedQueues) done(key string) {
+	q.Lock()
+	queue := q.queues[key][1:]
+	if len(queue) == 0 {
+		delete(q.queues, key)
+	} else {
+		q.queues[key] = queue
+	}
+	q.Unlock()
+	if len(queue) > 0 {
+		// to the back of ready, so that a busy key doesn't starve the others
+		q.ready <- key
+	}
+	<-q.pending
+}
+
+// orderedDispatchLoop decodes messages and puts them into the queue of their ordering key
+func (p *notifier) orderedDispatchLoop(workerWG *sync.WaitGroup, consumer messaging.Consumer, stopCh <-chan struct{}, queues *keyedQueues) {
+	defer workerWG.Done()
+
+	for p.waitUntilResumed(stopCh) {
+		var msg messaging.Message
+		select {
+		case m, ok := <-consumer.Messages():
+			if !ok {
+				return
+			}
+			msg = m
+		case <-stopCh:
+			return
+		}
+		decodedMsg, err := p.decode(msg)
+		if err != nil {
+			_ = msg.Nack()
+			continue
+		}
+		select {
+		case queues.pending <- struct{}{}:
+		case <-stopCh:
+			return
+		}
+		queues.push(p.orderingKey(decodedMsg), &orderedTask{kafkaMsg: msg, decodedMsg: decodedMsg})
+	}
+}
+
+func (p *notifier) orderedProcessLoop(workerWG *sync.WaitGroup, stopCh <-chan struct{}, queues *keyedQueues) {
+	defer workerWG.Done()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case key := <-queues.ready:
+			// queued messages are held until resumed, a drain only waits for the ones being delivered
+			if !p.beginDelivery(stopCh) {
+				return
+			}
+			task := queues.peek(key)
+			sw := p.metricScope.Timer(processLatency).Start()
+			err := p.notifySubscriber(task.decodedMsg, task.kafkaMsg)
+			sw.Stop()
+			p.endDelivery()
+			if err != nil && err != errNotifierStopped {
+				_ = task.kafkaMsg.Nack()
+			}
+			queues.done(key)
+		}
+	}
+}
+
+func (p *notifier) orderingKey(msg *indexer.Message) string {
+	if p.consumerConfig.Ordering == orderingRunID {
+		return msg.GetRunID()
+	}
+	return msg.GetWorkflowID()
+}
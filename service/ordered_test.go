@@ -0,0 +1,193 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/uber-go/tally"
+	"github.com/uber/cadence/.gen/go/indexer"
+	"github.com/uber/cadence/common/codec"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log/loggerimpl"
+	"github.com/uber/cadence/common/messaging"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+type (
+	// sequenceConsumer emits a fixed sequence of messages
+	sequenceConsumer struct {
+		messages chan messaging.Message
+		stopOnce sync.Once
+		acked    int64
+	}
+
+	sequenceMessage struct {
+		consumer *sequenceConsumer
+		payload  []byte
+		offset   int64
+	}
+
+	// orderRecordingSink records the order of the deliveries of each workflow,
+	// holding the ones of blockedWorkflowID until released
+	orderRecordingSink struct {
+		sync.Mutex
+		blockedWorkflowID string
+		released          chan struct{}
+		delivered         map[string][]string
+		inFlight          map[string]int
+		maxInFlight       int
+	}
+)
+
+func newSequenceConsumer(t *testing.T, workflowIDs []string) *sequenceConsumer {
+	c := &sequenceConsumer{messages: make(chan messaging.Message, len(workflowIDs))}
+	for offset, workflowID := range workflowIDs {
+		payload, err := codec.NewThriftRWEncoder().Encode(&indexer.Message{
+			MessageType: indexer.MessageTypeIndex.Ptr(),
+			DomainID:    stringPtr("domain-id"),
+			WorkflowID:  stringPtr(workflowID),
+			RunID:       stringPtr("run-id"),
+			Version:     int64Ptr(int64(offset)),
+			Fields:      map[string]*indexer.Field{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.messages <- &sequenceMessage{consumer: c, payload: payload, offset: int64(offset)}
+	}
+	return c
+}
+
+func (c *sequenceConsumer) Start() error { return nil }
+
+func (c *sequenceConsumer) Stop() {
+	c.stopOnce.Do(func() { close(c.messages) })
+}
+
+func (c *sequenceConsumer) Messages() <-chan messaging.Message {
+	return c.messages
+}
+
+func (m *sequenceMessage) Value() []byte    { return m.payload }
+func (m *sequenceMessage) Partition() int32 { return 0 }
+func (m *sequenceMessage) Offset() int64    { return m.offset }
+func (m *sequenceMessage) Nack() error      { return nil }
+
+func (m *sequenceMessage) Ack() error {
+	atomic.AddInt64(&m.consumer.acked, 1)
+	return nil
+}
+
+func (s *orderRecordingSink) Start() error { return nil }
+func (s *orderRecordingSink) Stop()        {}
+
+func (s *orderRecordingSink) Deliver(ctx context.Context, notification *Notification) error {
+	s.Lock()
+	s.inFlight[notification.WorkflowID]++
+	if s.inFlight[notification.WorkflowID] > s.maxInFlight {
+		s.maxInFlight = s.inFlight[notification.WorkflowID]
+	}
+	s.Unlock()
+
+	if notification.WorkflowID == s.blockedWorkflowID {
+		select {
+		case <-s.released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.inFlight[notification.WorkflowID]--
+	s.delivered[notification.WorkflowID] = append(s.delivered[notification.WorkflowID], notification.ID)
+	return nil
+}
+
+func (s *orderRecordingSink) getDelivered(workflowID string) []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.delivered[workflowID]...)
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	workflowIDs := []string{"blocked", "a", "b"}
+	const perWorkflow = 20
+	var sequence []string
+	for i := 0; i < perWorkflow*len(workflowIDs); i++ {
+		sequence = append(sequence, workflowIDs[i%len(workflowIDs)])
+	}
+	consumer := newSequenceConsumer(t, sequence)
+
+	sink := &orderRecordingSink{
+		blockedWorkflowID: "blocked",
+		released:          make(chan struct{}),
+		delivered:         make(map[string][]string),
+		inFlight:          make(map[string]int),
+	}
+	subscriber := &config.Subscriber{Name: "test"}
+	subscriber.Consumer.Concurrency = 4
+	subscriber.Consumer.Ordering = orderingWorkflowID
+	subscriber.Consumer.InitialOffset = initialOffsetOldest
+	p, err := newNotifierWithSink(&cconfig.KafkaConfig{}, subscriber, nil, sink, loggerimpl.NewNopLogger(), tally.NoopScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.newConsumer = func() (messaging.Consumer, error) {
+		return consumer, nil
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer stopWithTimeout(t, p)
+
+	// the workflow being held up doesn't block the others
+	for _, workflowID := range workflowIDs[1:] {
+		waitFor(t, "deliveries of "+workflowID, func() bool { return len(sink.getDelivered(workflowID)) == perWorkflow })
+	}
+	if delivered := sink.getDelivered("blocked"); len(delivered) > 0 {
+		t.Fatalf("expected no deliveries of the blocked workflow, got %v", delivered)
+	}
+	close(sink.released)
+	waitFor(t, "deliveries of the blocked workflow", func() bool { return len(sink.getDelivered("blocked")) == perWorkflow })
+	waitFor(t, "acks", func() bool { return atomic.LoadInt64(&consumer.acked) == int64(len(sequence)) })
+
+	for i, workflowID := range workflowIDs {
+		delivered := sink.getDelivered(workflowID)
+		for j, id := range delivered {
+			if expected := fmt.Sprintf("0-%v", i+j*len(workflowIDs)); id != expected {
+				t.Errorf("expected delivery %v of %v to be %v, got %v", j, workflowID, expected, delivered)
+				break
+			}
+		}
+	}
+	sink.Lock()
+	defer sink.Unlock()
+	if sink.maxInFlight != 1 {
+		t.Errorf("expected one delivery in flight per workflow, got %v", sink.maxInFlight)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/cadence/common/log/tag"
+)
+
+const (
+	subscriberStatePaused   = "paused"
+	subscriberStateDraining = "draining"
+	subscriberStateDrained  = "drained"
+
+	drainPollInterval = 100 * time.Millisecond
+)
+
+type (
+	// pauseStateStore persists the pause state of subscribers to a local file, so that it survives restarts
+	pauseStateStore struct {
+		sync.Mutex
+		path string
+	}
+
+	pauseStateFile struct {
+		// subscriber name -> paused or drained
+		Subscribers map[string]string `json:"subscribers"`
+	}
+)
+
+// pause stops taking new messages from the consumer. Messages are not acked, so they pile up in Kafka.
+func (p *notifier) pause() {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	if p.resumeCh == nil {
+		p.resumeCh = make(chan struct{})
+	}
+	if p.pauseState != subscriberStateDrained {
+		p.pauseState = subscriberStatePaused
+	}
+	p.logger.Info("notifier paused")
+}
+
+// drain pauses the notifier, and stops the consumer once the in-flight deliveries are finished.
+// Messages that are received but not delivered yet are not acked, they are consumed again after resuming.
+func (p *notifier) drain() {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	if p.resumeCh == nil {
+		p.resumeCh = make(chan struct{})
+	}
+	if p.pauseState == subscriberStateDraining || p.pauseState == subscriberStateDrained {
+		return
+	}
+	p.pauseState = subscriberStateDraining
+	p.logger.Info("notifier draining")
+
+	resumeCh := p.resumeCh
+	go func() {
+		ticker := time.NewTicker(drainPollInterval)
+		defer ticker.Stop()
+		for atomic.LoadInt64(&p.inFlight) > 0 {
+			select {
+			case <-resumeCh:
+				return
+			case <-p.shutdownCh:
+				return
+			case <-ticker.C:
+			}
+		}
+		p.pauseLock.Lock()
+		defer p.pauseLock.Unlock()
+		if p.resumeCh == resumeCh {
+			p.pauseState = subscriberStateDrained
+			p.logger.Info("notifier drained")
+			select {
+			case p.drainedC <- struct{}{}:
+			default:
+			}
+		}
+	}()
+}
+
+func (p *notifier) resume() {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	if p.resumeCh != nil {
+		close(p.resumeCh)
+		p.resumeCh = nil
+	}
+	p.pauseState = ""
+	p.logger.Info("notifier resumed")
+}
+
+// getPauseState returns paused, draining, drained, or empty if not paused
+func (p *notifier) getPauseState() string {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	return p.pauseState
+}
+
+// waitUntilResumed blocks while the notifier is paused, it returns false if stopCh is closed
+func (p *notifier) waitUntilResumed(stopCh <-chan struct{}) bool {
+	return p.awaitResume(stopCh, false)
+}
+
+// beginDelivery waits until resumed and counts the delivery as in flight, which must be ended by endDelivery.
+// Checking the pause and counting happen under the same lock, so that a drain never misses a delivery.
+// It returns false if stopCh is closed.
+func (p *notifier) beginDelivery(stopCh <-chan struct{}) bool {
+	return p.awaitResume(stopCh, true)
+}
+
+func (p *notifier) endDelivery() {
+	atomic.AddInt64(&p.inFlight, -1)
+}
+
+func (p *notifier) awaitResume(stopCh <-chan struct{}, countInFlight bool) bool {
+	for {
+		select {
+		case <-stopCh:
+			return false
+		default:
+		}
+		p.pauseLock.Lock()
+		resumeCh := p.resumeCh
+		if resumeCh == nil && countInFlight {
+			atomic.AddInt64(&p.inFlight, 1)
+		}
+		p.pauseLock.Unlock()
+		if resumeCh == nil {
+			return true
+		}
+		select {
+		case <-resumeCh:
+		case <-stopCh:
+			return false
+		}
+	}
+}
+
+func newPauseStateStore(path string) *pauseStateStore {
+	return &pauseStateStore{path: path}
+}
+
+// load returns the persisted pause state of subscribers, empty if the file doesn't exist yet
+func (s *pauseStateStore) load() (map[string]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.read()
+}
+
+// set persists the pause state of a subscriber, an empty state removes it
+func (s *pauseStateStore) set(subscriber string, state string) error {
+	s.Lock()
+	defer s.Unlock()
+	states, err := s.read()
+	if err != nil {
+		return err
+	}
+	if state == "" {
+		delete(states, subscriber)
+	} else {
+		states[subscriber] = state
+	}
+
+	content, err := json.MarshalIndent(&pauseStateFile{Subscribers: states}, "", "    ")
+	if err != nil {
+		return err
+	}
+	// write to a temp file and rename, so that a crash never leaves a partial file
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+func (s *pauseStateStore) read() (map[string]string, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create state file directory: %v", err)
+		}
+		return make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+	var stateFile pauseStateFile
+	if err := json.Unmarshal(content, &stateFile); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %v: %v", s.path, err)
+	}
+	if stateFile.Subscribers == nil {
+		stateFile.Subscribers = make(map[string]string)
+	}
+	return stateFile.Subscribers, nil
+}
+
+// restorePauseState applies the persisted state to a notifier before it's started
+func restorePauseState(n *notifier, states map[string]string) {
+	switch states[n.subscriberConfig.Name] {
+	case subscriberStatePaused:
+		n.pause()
+	case subscriberStateDraining, subscriberStateDrained:
+		// nothing is in flight after a restart
+		n.pause()
+		n.pauseLock.Lock()
+		n.pauseState = subscriberStateDrained
+		n.pauseLock.Unlock()
+	case "":
+	default:
+		n.logger.Warn("unknown subscriber state in state file", tag.Value(states[n.subscriberConfig.Name]))
+	}
+}
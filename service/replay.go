@@ -0,0 +1,381 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/uber-go/tally"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+	"golang.org/x/time/rate"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+const (
+	replayProgressInterval = 5 * time.Second
+	// max time to wait for the next message of a range that is known to exist
+	replayFetchTimeout = time.Minute
+)
+
+type (
+	// ReplayOptions defines what to replay to a subscriber. The range is given either by offsets or by time.
+	ReplayOptions struct {
+		// Subscriber is the name of the subscriber to deliver to
+		Subscriber string
+		// Partitions of the visibility topic to replay, empty means all
+		Partitions []int32
+		// StartOffset and EndOffset are the inclusive offset range, -1 means the oldest/newest
+		StartOffset int64
+		EndOffset   int64
+		// StartTime and EndTime are the time range of the Kafka message timestamps, used if not zero
+		StartTime time.Time
+		EndTime   time.Time
+		// RateLimit is the max messages per second, 0 means unlimited
+		RateLimit float64
+		// DryRun writes the notifications to Output instead of delivering them
+		DryRun bool
+		Output io.Writer
+		// Progress receives progress reports, can be nil
+		Progress io.Writer
+	}
+
+	// ReplayResult counts the replayed messages
+	ReplayResult struct {
+		Processed int64
+		// delivered or filtered out
+		Succeeded int64
+		Failed    int64
+	}
+
+	// replayMessage is a message read outside of any consumer group, acking only records the result
+	replayMessage struct {
+		msg    *sarama.ConsumerMessage
+		acked  bool
+		nacked bool
+	}
+
+	// stdoutSink writes notifications as JSON lines instead of delivering them, for dry runs
+	stdoutSink struct {
+		output io.Writer
+	}
+
+	// partitionRange is the offset range [start, end) of a partition to replay
+	partitionRange struct {
+		partition int32
+		start     int64
+		end       int64
+	}
+)
+
+// Replay re-delivers the visibility messages of a range to a subscriber, through the same filtering and sink
+// as live notifications. It reads the partitions directly without joining any consumer group,
+// so the committed offsets of the subscriber's consumer group are never changed.
+func Replay(ctx context.Context, cfg *config.Config, options *ReplayOptions, logger log.Logger, metricScope tally.Scope) (*ReplayResult, error) {
+	subscriberConfig, err := getSubscriberConfig(cfg, options.Subscriber)
+	if err != nil {
+		return nil, err
+	}
+	application, ok := cfg.Kafka.Applications[subscriberConfig.Name]
+	if !ok {
+		return nil, fmt.Errorf("missing kafka.applications entry for subscriber %v", subscriberConfig.Name)
+	}
+
+	domainResolver, stopDomainResolver, err := startDomainResolver(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer stopDomainResolver()
+
+	logger = logger.WithTags(tag.Name("Replay-" + subscriberConfig.Name))
+	metricScope = metricScope.Tagged(map[string]string{subscriberTag: subscriberConfig.Name})
+	var sink Sink = &stdoutSink{output: options.Output}
+	if !options.DryRun {
+		sink, err = newSink(&SinkParams{
+			Subscriber:  subscriberConfig,
+			KafkaConfig: &cfg.Kafka,
+			Logger:      logger,
+			MetricScope: metricScope,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	p, err := newNotifierWithSink(&cfg.Kafka, subscriberConfig, domainResolver, sink, logger, metricScope)
+	if err != nil {
+		return nil, err
+	}
+	if err := sink.Start(); err != nil {
+		return nil, err
+	}
+	defer sink.Stop()
+
+	client, err := newReplayClient(&cfg.Kafka, application.Topic)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	ranges, err := getReplayRanges(client, application.Topic, options)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	// stop deliveries in progress when the replay is canceled
+	go func() {
+		<-ctx.Done()
+		p.shutdownCancel()
+	}()
+	defer p.shutdownCancel()
+
+	var limiter *rate.Limiter
+	if options.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(options.RateLimit), 1)
+	}
+	result := &ReplayResult{}
+	lastProgress := time.Now()
+	for _, r := range ranges {
+		r := r
+		err := readPartitionRange(ctx, consumer, application.Topic, r, func(msg *sarama.ConsumerMessage) error {
+			if limiter != nil {
+				if err := limiter.Wait(ctx); err != nil {
+					return err
+				}
+			}
+			replayMsg := &replayMessage{msg: msg}
+			if err := p.process(replayMsg); err != nil && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			result.Processed++
+			if replayMsg.acked {
+				result.Succeeded++
+			} else {
+				result.Failed++
+			}
+
+			if options.Progress != nil && time.Since(lastProgress) >= replayProgressInterval {
+				lastProgress = time.Now()
+				fmt.Fprintf(options.Progress, "partition %v: offset %v of [%v, %v), processed %v, failed %v\n",
+					r.partition, msg.Offset, r.start, r.end, result.Processed, result.Failed)
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+		if options.Progress != nil && r.start < r.end {
+			fmt.Fprintf(options.Progress, "partition %v: done [%v, %v), processed %v, failed %v\n",
+				r.partition, r.start, r.end, result.Processed, result.Failed)
+		}
+	}
+	return result, nil
+}
+
+// readPartitionRange calls handle for each message of the range in order, until handle returns an error
+func readPartitionRange(
+	ctx context.Context,
+	consumer sarama.Consumer,
+	topic string,
+	r partitionRange,
+	handle func(msg *sarama.ConsumerMessage) error,
+) error {
+	if r.start >= r.end {
+		return nil
+	}
+	partitionConsumer, err := consumer.ConsumePartition(topic, r.partition, r.start)
+	if err != nil {
+		return fmt.Errorf("failed to consume partition %v: %v", r.partition, err)
+	}
+	defer partitionConsumer.AsyncClose()
+
+	for offset := r.start; offset < r.end; {
+		var msg *sarama.ConsumerMessage
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case consumerErr := <-partitionConsumer.Errors():
+			return consumerErr
+		case msg = <-partitionConsumer.Messages():
+		case <-time.After(replayFetchTimeout):
+			return fmt.Errorf("timed out reading partition %v at offset %v", r.partition, offset)
+		}
+		// offsets may have gaps, e.g. in compacted topics
+		offset = msg.Offset + 1
+		if msg.Offset >= r.end {
+			break
+		}
+		if err := handle(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startDomainResolver starts the configured domain resolver for the tools running outside of the service.
+// The resolver is nil if there is none, the returned func stops it.
+func startDomainResolver(cfg *config.Config, logger log.Logger) (DomainResolver, func(), error) {
+	resolverConfig := cfg.Service.DomainResolver
+	if resolverConfig.MappingFile == "" {
+		return nil, func() {}, nil
+	}
+	resolver := newCachedDomainResolver(newStaticDomainSource(resolverConfig.MappingFile), resolverConfig.RefreshInterval, logger)
+	if err := resolver.Start(); err != nil {
+		return nil, nil, err
+	}
+	return resolver, resolver.Stop, nil
+}
+
+func newReplayClient(kafkaConfig *cconfig.KafkaConfig, topic string) (sarama.Client, error) {
+	brokers, err := getBrokersForTopic(kafkaConfig, topic)
+	if err != nil {
+		return nil, err
+	}
+	saramaConfig, err := newSaramaConfig(kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+	saramaConfig.Consumer.Return.Errors = true
+	return sarama.NewClient(brokers, saramaConfig)
+}
+
+// getReplayRanges converts the options to offset ranges per partition
+func getReplayRanges(client sarama.Client, topic string, options *ReplayOptions) ([]partitionRange, error) {
+	if (options.StartOffset >= 0 || options.EndOffset >= 0) && (!options.StartTime.IsZero() || !options.EndTime.IsZero()) {
+		return nil, errors.New("offset range and time range can't be used together")
+	}
+	if options.StartOffset >= 0 && options.EndOffset >= 0 && options.StartOffset > options.EndOffset {
+		return nil, fmt.Errorf("start offset %v is after end offset %v", options.StartOffset, options.EndOffset)
+	}
+	if !options.StartTime.IsZero() && !options.EndTime.IsZero() && options.StartTime.After(options.EndTime) {
+		return nil, fmt.Errorf("start time %v is after end time %v", options.StartTime, options.EndTime)
+	}
+	topicPartitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	partitions := append([]int32{}, options.Partitions...)
+	if len(partitions) == 0 {
+		partitions = topicPartitions
+	}
+	for _, partition := range options.Partitions {
+		found := false
+		for _, p := range topicPartitions {
+			found = found || p == partition
+		}
+		if !found {
+			return nil, fmt.Errorf("partition %v not found in topic %v", partition, topic)
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	var ranges []partitionRange
+	for _, partition := range partitions {
+		oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		r := partitionRange{partition: partition, start: oldest, end: newest}
+		if options.StartOffset >= 0 && options.StartOffset > oldest {
+			r.start = options.StartOffset
+		}
+		if options.EndOffset >= 0 && options.EndOffset+1 < newest {
+			r.end = options.EndOffset + 1
+		}
+		if !options.StartTime.IsZero() {
+			if r.start, err = getOffsetForTime(client, topic, partition, options.StartTime, newest); err != nil {
+				return nil, err
+			}
+		}
+		if !options.EndTime.IsZero() {
+			if r.end, err = getOffsetForTime(client, topic, partition, options.EndTime, newest); err != nil {
+				return nil, err
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// getOffsetForTime returns the first offset with a timestamp at or after t, or newest if there isn't any
+func getOffsetForTime(client sarama.Client, topic string, partition int32, t time.Time, newest int64) (int64, error) {
+	offset, err := client.GetOffset(topic, partition, t.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return newest, nil
+	}
+	return offset, nil
+}
+
+func (m *replayMessage) Value() []byte {
+	return m.msg.Value
+}
+
+func (m *replayMessage) Partition() int32 {
+	return m.msg.Partition
+}
+
+func (m *replayMessage) Offset() int64 {
+	return m.msg.Offset
+}
+
+func (m *replayMessage) Ack() error {
+	m.acked = true
+	return nil
+}
+
+func (m *replayMessage) Nack() error {
+	m.nacked = true
+	return nil
+}
+
+func (s *stdoutSink) Start() error {
+	return nil
+}
+
+func (s *stdoutSink) Stop() {}
+
+func (s *stdoutSink) Deliver(_ context.Context, notification *Notification) error {
+	jsonBytes, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(s.output, string(jsonBytes))
+	return err
+}
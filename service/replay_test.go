@@ -0,0 +1,145 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// fakeReplayClient serves the offsets of a topic with partitions 0 to 2, each holding the offsets 10 to 20
+// with one message per second from replayStartTime
+type fakeReplayClient struct {
+	sarama.Client
+}
+
+var replayStartTime = time.Date(2021, 1, 2, 3, 4, 0, 0, time.UTC)
+
+func (c *fakeReplayClient) Partitions(string) ([]int32, error) {
+	return []int32{2, 0, 1}, nil
+}
+
+func (c *fakeReplayClient) GetOffset(_ string, _ int32, t int64) (int64, error) {
+	switch t {
+	case sarama.OffsetOldest:
+		return 10, nil
+	case sarama.OffsetNewest:
+		return 20, nil
+	}
+	offset := 10 + (t-replayStartTime.UnixNano()/int64(time.Millisecond)+999)/1000
+	if offset < 10 {
+		return 10, nil
+	}
+	if offset >= 20 {
+		return -1, nil
+	}
+	return offset, nil
+}
+
+func TestGetReplayRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  ReplayOptions
+		expected []partitionRange
+		err      bool
+	}{
+		{
+			name:     "all",
+			options:  ReplayOptions{StartOffset: -1, EndOffset: -1},
+			expected: []partitionRange{{0, 10, 20}, {1, 10, 20}, {2, 10, 20}},
+		},
+		{
+			name:     "offset range",
+			options:  ReplayOptions{Partitions: []int32{1}, StartOffset: 12, EndOffset: 14},
+			expected: []partitionRange{{1, 12, 15}},
+		},
+		{
+			name:     "open-ended offset range",
+			options:  ReplayOptions{Partitions: []int32{1, 0}, StartOffset: 15, EndOffset: -1},
+			expected: []partitionRange{{0, 15, 20}, {1, 15, 20}},
+		},
+		{
+			name:     "offset range beyond the partition",
+			options:  ReplayOptions{Partitions: []int32{0}, StartOffset: 5, EndOffset: 100},
+			expected: []partitionRange{{0, 10, 20}},
+		},
+		{
+			name:     "single offset",
+			options:  ReplayOptions{Partitions: []int32{0}, StartOffset: 12, EndOffset: 12},
+			expected: []partitionRange{{0, 12, 13}},
+		},
+		{
+			name:    "start offset after end offset",
+			options: ReplayOptions{StartOffset: 15, EndOffset: 12},
+			err:     true,
+		},
+		{
+			name:     "time range",
+			options:  ReplayOptions{Partitions: []int32{2}, StartOffset: -1, EndOffset: -1, StartTime: replayStartTime.Add(2 * time.Second), EndTime: replayStartTime.Add(5 * time.Second)},
+			expected: []partitionRange{{2, 12, 15}},
+		},
+		{
+			name:     "open-ended time range",
+			options:  ReplayOptions{Partitions: []int32{2}, StartOffset: -1, EndOffset: -1, StartTime: replayStartTime.Add(5 * time.Second)},
+			expected: []partitionRange{{2, 15, 20}},
+		},
+		{
+			name:     "time range after the newest message",
+			options:  ReplayOptions{Partitions: []int32{2}, StartOffset: -1, EndOffset: -1, StartTime: replayStartTime.Add(time.Hour)},
+			expected: []partitionRange{{2, 20, 20}},
+		},
+		{
+			name:    "start time after end time",
+			options: ReplayOptions{StartOffset: -1, EndOffset: -1, StartTime: replayStartTime.Add(5 * time.Second), EndTime: replayStartTime},
+			err:     true,
+		},
+		{
+			name:    "offset and time range",
+			options: ReplayOptions{StartOffset: 12, EndOffset: -1, StartTime: replayStartTime},
+			err:     true,
+		},
+		{
+			name:    "partition not found",
+			options: ReplayOptions{Partitions: []int32{0, 3}, StartOffset: -1, EndOffset: -1},
+			err:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranges, err := getReplayRanges(&fakeReplayClient{}, "visibility", &test.options)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %v", ranges)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ranges, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, ranges)
+			}
+		})
+	}
+}
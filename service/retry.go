@@ -0,0 +1,312 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/uber-go/tally"
+	"github.com/uber/cadence/common/backoff"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+const (
+	defaultMaxRetryInterval   = 10 * time.Second
+	defaultBackoffCoefficient = 2.0
+	defaultExpirationInterval = time.Minute
+	defaultJitter             = 0.2
+
+	// retryDone is returned by ComputeNextDelay to stop retrying, same as the backoff package
+	retryDone time.Duration = -1
+	// upper bound of honoring Retry-After, so that a receiver can't hold a worker for long
+	maxRetryAfter = 5 * time.Minute
+
+	transportErrorTimeout    = "timeout"
+	transportErrorConnection = "connection"
+	transportErrorDNS        = "dns"
+	transportErrorTLS        = "tls"
+)
+
+var (
+	// 408 isn't retried by default, as it's sent when the receiver times out reading the request, which a retry rarely fixes
+	defaultRetryableStatusCodes = []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+	defaultRetryableTransportErrors = []string{transportErrorTimeout, transportErrorConnection, transportErrorDNS}
+	knownTransportErrors            = map[string]bool{
+		transportErrorTimeout:    true,
+		transportErrorConnection: true,
+		transportErrorDNS:        true,
+		transportErrorTLS:        true,
+	}
+)
+
+type (
+	// retryPolicy is an exponential backoff retry policy with configurable jitter
+	retryPolicy struct {
+		initialInterval    time.Duration
+		maximumInterval    time.Duration
+		expirationInterval time.Duration
+		backoffCoefficient float64
+		jitter             float64
+		maximumAttempts    int
+	}
+
+	// retryClassifier decides whether a failed webhook request should be retried
+	retryClassifier struct {
+		statusCodes     map[int]bool
+		transportErrors map[string]bool
+	}
+
+	// statusCodeError is returned when the receiver responded but didn't accept the notification
+	statusCodeError struct {
+		statusCode int
+		// from the Retry-After header of 429 and 503 responses, 0 if there is none
+		retryAfter time.Duration
+	}
+)
+
+var _ backoff.RetryPolicy = (*retryPolicy)(nil)
+
+func newRetryPolicy(cfg *config.RetryPolicy) (*retryPolicy, error) {
+	p := &retryPolicy{
+		initialInterval:    cfg.RetryInterval,
+		maximumInterval:    cfg.MaxRetryInterval,
+		expirationInterval: cfg.ExpirationInterval,
+		backoffCoefficient: cfg.BackoffCoefficient,
+		jitter:             defaultJitter,
+		maximumAttempts:    cfg.MaxRetries,
+	}
+	if p.initialInterval <= 0 {
+		p.initialInterval = defaultRetryInterval
+	}
+	if p.maximumInterval <= 0 {
+		p.maximumInterval = defaultMaxRetryInterval
+	}
+	if p.expirationInterval <= 0 {
+		p.expirationInterval = defaultExpirationInterval
+	}
+	if p.backoffCoefficient == 0 {
+		p.backoffCoefficient = defaultBackoffCoefficient
+	}
+	if cfg.Jitter != nil {
+		p.jitter = *cfg.Jitter
+	}
+
+	if p.backoffCoefficient < 1 {
+		return nil, fmt.Errorf("backoffCoefficient must be at least 1, got %v", p.backoffCoefficient)
+	}
+	if p.jitter < 0 || p.jitter > 1 {
+		return nil, fmt.Errorf("jitter must be within [0, 1], got %v", p.jitter)
+	}
+	if p.maximumAttempts < 0 {
+		return nil, fmt.Errorf("maxRetries must not be negative, got %v", p.maximumAttempts)
+	}
+	return p, nil
+}
+
+// ComputeNextDelay returns the interval before the next retry, or retryDone if no more retry should be made
+func (p *retryPolicy) ComputeNextDelay(elapsedTime time.Duration, numAttempts int) time.Duration {
+	if p.maximumAttempts > 0 && numAttempts >= p.maximumAttempts {
+		return retryDone
+	}
+	if elapsedTime >= p.expirationInterval {
+		return retryDone
+	}
+
+	nextInterval := float64(p.initialInterval) * math.Pow(p.backoffCoefficient, float64(numAttempts))
+	nextInterval = math.Min(nextInterval, float64(p.maximumInterval))
+	nextInterval = math.Min(nextInterval, float64(p.expirationInterval-elapsedTime))
+	if nextInterval <= 0 {
+		return retryDone
+	}
+
+	if p.jitter > 0 {
+		nextInterval = backoff.JitFloat64(nextInterval, p.jitter)
+	}
+	return time.Duration(nextInterval)
+}
+
+func newRetryClassifier(webhook *config.Webhook) (*retryClassifier, error) {
+	statusCodes := webhook.RetryableStatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = defaultRetryableStatusCodes
+	}
+	transportErrors := webhook.RetryableTransportErrors
+	if len(transportErrors) == 0 {
+		transportErrors = defaultRetryableTransportErrors
+	}
+
+	c := &retryClassifier{
+		statusCodes:     make(map[int]bool),
+		transportErrors: make(map[string]bool),
+	}
+	for _, code := range statusCodes {
+		c.statusCodes[code] = true
+	}
+	for _, class := range transportErrors {
+		if !knownTransportErrors[class] {
+			return nil, fmt.Errorf("unknown retryable transport error %q", class)
+		}
+		c.transportErrors[class] = true
+	}
+	return c, nil
+}
+
+// isRetryable returns true if the error is worth retrying
+func (c *retryClassifier) isRetryable(err error) bool {
+	var statusErr *statusCodeError
+	if errors.As(err, &statusErr) {
+		return c.statusCodes[statusErr.statusCode]
+	}
+	class := classifyTransportError(err)
+	return class != "" && c.transportErrors[class]
+}
+
+// classifyTransportError returns the class of an error from the HTTP client, empty if it's not a transport error
+func classifyTransportError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateErr x509.CertificateInvalidError
+	var recordHeaderErr tls.RecordHeaderError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return transportErrorDNS
+	case errors.As(err, &unknownAuthorityErr), errors.As(err, &hostnameErr),
+		errors.As(err, &certificateErr), errors.As(err, &recordHeaderErr):
+		return transportErrorTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return transportErrorTimeout
+	case errors.As(err, &opErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return transportErrorConnection
+	default:
+		return ""
+	}
+}
+
+// retryDelivery calls op until it succeeds, fails with a non-retryable error or the retry policy gives up.
+// Every attempt waits for and is recorded by the circuit breaker of the context, if any.
+// The returned error is a *DeliveryError, or the context error if ctx is done while waiting for the next attempt.
+func retryDelivery(
+	ctx context.Context,
+	policy *retryPolicy,
+	isRetryable func(error) bool,
+	metricScope tally.Scope,
+	op func(attempt int) error,
+) error {
+	breaker := circuitBreakerFromContext(ctx)
+	start := time.Now()
+	retrier := backoff.NewRetrier(policy, backoff.SystemClock)
+	for attempt := 1; ; attempt++ {
+		trial := false
+		if breaker != nil {
+			var err error
+			if trial, err = breaker.allow(ctx); err != nil {
+				return err
+			}
+		}
+		err := op(attempt)
+		if breaker != nil {
+			breaker.recordResult(ctx, trial, err, isRejection(err, isRetryable))
+		}
+		if err == nil {
+			return nil
+		}
+		if !isRetryable(err) {
+			return newDeliveryError(err, attempt)
+		}
+		next := retrier.NextBackOff()
+		if next == retryDone {
+			return newDeliveryError(err, attempt)
+		}
+		var statusErr *statusCodeError
+		if errors.As(err, &statusErr) && statusErr.retryAfter > next {
+			// honored within the expiration of the retry policy, so that a delivery doesn't outlive it
+			next = statusErr.retryAfter
+			if remaining := policy.expirationInterval - time.Since(start); next > remaining {
+				next = remaining
+			}
+		}
+
+		metricScope.Counter(deliveryRetries).Inc(1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(next):
+		}
+	}
+}
+
+// isRejection returns true if the receiver responded with a status code that isn't retried, e.g. 400
+func isRejection(err error, isRetryable func(error) bool) bool {
+	var statusErr *statusCodeError
+	return errors.As(err, &statusErr) && !isRetryable(err)
+}
+
+func newDeliveryError(err error, attempts int) *DeliveryError {
+	deliveryErr := &DeliveryError{Err: err, Attempts: attempts}
+	var statusErr *statusCodeError
+	if errors.As(err, &statusErr) {
+		deliveryErr.StatusCode = statusErr.statusCode
+	}
+	return deliveryErr
+}
+
+// parseRetryAfter returns the delay of a Retry-After header in seconds or as an HTTP date, 0 if it's missing or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		delay = t.Sub(now)
+	}
+	switch {
+	case delay < 0:
+		return 0
+	case delay > maxRetryAfter:
+		return maxRetryAfter
+	default:
+		return delay
+	}
+}
+
+func (e *statusCodeError) Error() string {
+	return fmt.Sprintf("HTTP request failed with status code %v", e.statusCode)
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/uber-go/tally"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+func TestRetryAfterWithinExpiration(t *testing.T) {
+	policy, err := newRetryPolicy(&config.RetryPolicy{RetryInterval: time.Millisecond, ExpirationInterval: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	classifier, err := newRetryClassifier(&config.Webhook{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	attempts := 0
+	err = retryDelivery(context.Background(), policy, classifier.isRetryable, tally.NoopScope, func(int) error {
+		attempts++
+		if attempts == 1 {
+			return &statusCodeError{statusCode: http.StatusServiceUnavailable, retryAfter: time.Minute}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("expected to succeed on the second attempt, got %v after %v attempts", err, attempts)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Retry-After to be clamped to the expiration interval, waited %v", elapsed)
+	}
+}
+
+func TestDefaultRetryableStatusCodes(t *testing.T) {
+	classifier, err := newRetryClassifier(&config.Webhook{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code, retryable := range map[int]bool{
+		http.StatusBadRequest:          false,
+		http.StatusRequestTimeout:      false,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusNotImplemented:      false,
+		http.StatusServiceUnavailable:  true,
+	} {
+		if classifier.isRetryable(&statusCodeError{statusCode: code}) != retryable {
+			t.Errorf("expected status code %v to be retryable: %v", code, retryable)
+		}
+	}
+}
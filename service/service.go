@@ -7,26 +7,39 @@
 // copies of the Software, and to permit persons to whom the Software is
 // furnished to do so, subject to the following conditions:
 //
-// The above copyright notice and this permission notice shall be included in all
-// copies or substantial portions of the Software.
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
 //
 // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
 // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
 // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
 // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
 // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
-// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
-// SOFTWARE.
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
 
 package service
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/uber-go/tally"
 	"github.com/uber/cadence/common"
+	cconfig "github.com/uber/cadence/common/config"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/messaging"
 	"github.com/uber/cadence/common/messaging/kafka"
 	"github.com/uber/cadence/common/metrics"
 	"github.com/uber/cadence/common/service"
@@ -34,6 +47,11 @@ import (
 	"github.com/cadence-oss/cadence-notification/common/config"
 )
 
+const (
+	// max time to wait for the in-flight deliveries of a removed or changed subscriber
+	reloadDrainTimeout = time.Minute
+)
+
 type (
 	// Service represents the cadence notification service. This service hosts background processing for delivering notifications
 	Service struct {
@@ -42,21 +60,44 @@ type (
 		logger      log.Logger
 		metricScope tally.Scope
 		config      *config.Config
+		// nil if hot reload is disabled
+		reloader *ConfigReloader
+
+		metricsClient metrics.Client
+		// client of s.config.Kafka, replaced when a reload changes the applications or topics
+		kafkaClient    messaging.Client
+		domainResolver DomainResolver
+		stateStore     *pauseStateStore
+		admin          *adminServer
+		// subscriber name -> running notifier, only accessed by the goroutine of Start
+		notifiers map[string]*notifier
+	}
+
+	// ConfigReloader reloads the config for hot reloading subscribers.
+	// Only changes of service.subscribers, kafka.applications and kafka.topics take effect, other changes require a restart.
+	ConfigReloader struct {
+		// Load returns the latest config
+		Load func() (*config.Config, error)
+		// Dir is the config directory watched for changes when service.configReload.watchInterval is set
+		Dir string
 	}
 )
 
-// NewService builds a new cadence-worker service
+// NewService builds a new cadence-worker service, reloader can be nil to disable hot reload
 func NewService(
 	config *config.Config,
+	reloader *ConfigReloader,
 	logger log.Logger,
 	metricScope tally.Scope,
 ) (*Service, error) {
 	return &Service{
 		status:      common.DaemonStatusInitialized,
 		config:      config,
+		reloader:    reloader,
 		logger:      logger,
 		metricScope: metricScope,
 		stopC:       make(chan struct{}),
+		notifiers:   make(map[string]*notifier),
 	}, nil
 }
 
@@ -67,23 +108,48 @@ func (s *Service) Start() {
 	}
 	s.logger.Info("notification service starting")
 
-	metricsClient := metrics.NewClient(s.metricScope, service.GetMetricsServiceIdx(service.Worker, s.logger))
-	kafkaClient := kafka.NewKafkaClient(&s.config.Kafka, metricsClient, s.logger, s.metricScope, false)
-	var notifiers []*notifier
-	for _, sub := range s.config.Service.Subscribers {
-		n, err := newNotifier(kafkaClient, &sub, s.logger, s.metricScope)
+	s.metricsClient = metrics.NewClient(s.metricScope, service.GetMetricsServiceIdx(service.Worker, s.logger))
+	s.kafkaClient = kafka.NewKafkaClient(&s.config.Kafka, s.metricsClient, s.logger, s.metricScope, false)
+
+	if stateFile := s.config.Service.Admin.StateFile; stateFile != "" {
+		s.stateStore = newPauseStateStore(stateFile)
+	}
+	pauseStates, err := s.loadPauseStates()
+	if err != nil {
+		s.logger.Fatal("failed to load subscriber state file", tag.Error(err))
+	}
+
+	if s.config.Service.Admin.ListenAddress != "" {
+		s.admin = newAdminServer(s.config, s.stateStore, s.logger)
+		s.admin.Start()
+		defer s.admin.Stop()
+	}
+
+	if resolverConfig := s.config.Service.DomainResolver; resolverConfig.MappingFile != "" {
+		resolver := newCachedDomainResolver(newStaticDomainSource(resolverConfig.MappingFile), resolverConfig.RefreshInterval, s.logger)
+		if err := resolver.Start(); err != nil {
+			s.logger.Fatal("failed to start domain resolver", tag.Error(err))
+		}
+		defer resolver.Stop()
+		s.domainResolver = resolver
+	}
+
+	for i := range s.config.Service.Subscribers {
+		n, err := s.newNotifier(s.kafkaClient, &s.config.Kafka, &s.config.Service.Subscribers[i])
 		if err != nil {
 			s.logger.Fatal("failed to start notifier", tag.Error(err))
 		}
-		err = n.Start()
-		if err != nil {
+		if err := s.startNotifier(n, pauseStates); err != nil {
 			s.logger.Fatal("failed to start notifier", tag.Error(err))
 		}
-		notifiers = append(notifiers, n)
+	}
+	if s.admin != nil {
+		s.admin.setAllAdded()
 	}
 	s.logger.Info("notification service started")
-	<-s.stopC
-	for _, n := range notifiers {
+
+	s.reloadLoop()
+	for _, n := range s.notifiers {
 		n.Stop()
 	}
 }
@@ -95,3 +161,299 @@ func (s *Service) Stop() {
 	}
 	close(s.stopC)
 }
+
+func (s *Service) newNotifier(
+	kafkaClient messaging.Client,
+	kafkaConfig *cconfig.KafkaConfig,
+	subscriberConfig *config.Subscriber,
+) (*notifier, error) {
+	return newNotifier(kafkaClient, kafkaConfig, subscriberConfig, s.domainResolver, s.logger, s.metricScope)
+}
+
+// startNotifier registers the notifier once it's started, a notifier failing to start is stopped
+func (s *Service) startNotifier(n *notifier, pauseStates map[string]string) error {
+	restorePauseState(n, pauseStates)
+	if err := n.Start(); err != nil {
+		n.Stop()
+		return err
+	}
+	if s.admin != nil {
+		s.admin.addNotifier(n)
+	}
+	s.notifiers[n.subscriberConfig.Name] = n
+	return nil
+}
+
+// drainAndStopNotifier waits for the in-flight deliveries before stopping the notifier
+func (s *Service) drainAndStopNotifier(n *notifier) {
+	n.drain()
+	deadline := time.Now().Add(reloadDrainTimeout)
+	for n.getPauseState() != subscriberStateDrained && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	n.Stop()
+	if s.admin != nil {
+		s.admin.removeNotifier(n)
+	}
+	delete(s.notifiers, n.subscriberConfig.Name)
+}
+
+func (s *Service) loadPauseStates() (map[string]string, error) {
+	if s.stateStore == nil {
+		return make(map[string]string), nil
+	}
+	return s.stateStore.load()
+}
+
+// reloadLoop reloads the config on SIGHUP or changes of the config directory, until the service is stopped
+func (s *Service) reloadLoop() {
+	if s.reloader == nil {
+		<-s.stopC
+		return
+	}
+
+	sighupC := make(chan os.Signal, 1)
+	signal.Notify(sighupC, syscall.SIGHUP)
+	defer signal.Stop(sighupC)
+
+	var watchC <-chan time.Time
+	var lastFingerprint string
+	if watchInterval := s.config.Service.ConfigReload.WatchInterval; watchInterval > 0 {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		watchC = ticker.C
+		lastFingerprint, _ = fingerprintDir(s.reloader.Dir)
+	}
+
+	for {
+		select {
+		case <-s.stopC:
+			return
+		case <-sighupC:
+			s.logger.Info("received SIGHUP, reloading config")
+			s.reload()
+		case <-watchC:
+			fingerprint, err := fingerprintDir(s.reloader.Dir)
+			if err != nil {
+				s.logger.Warn("failed to check config directory for changes", tag.Error(err))
+				continue
+			}
+			if fingerprint != lastFingerprint {
+				lastFingerprint = fingerprint
+				s.logger.Info("config directory changed, reloading config")
+				s.reload()
+			}
+		}
+	}
+}
+
+// reload applies the subscriber changes of the latest config. Nothing is changed if the new config is invalid.
+func (s *Service) reload() {
+	newConfig, err := s.reloader.Load()
+	if err != nil {
+		s.logger.Error("rejected config reload, failed to load config", tag.Error(err))
+		return
+	}
+	if err := ValidateConfig(newConfig); err != nil {
+		s.logger.Error("rejected config reload, invalid config", tag.Error(err))
+		return
+	}
+	if isKafkaConnectionChanged(&s.config.Kafka, &newConfig.Kafka) ||
+		!reflect.DeepEqual(newConfig.Service.Admin, s.config.Service.Admin) ||
+		!reflect.DeepEqual(newConfig.Service.DomainResolver, s.config.Service.DomainResolver) ||
+		!reflect.DeepEqual(newConfig.Service.Metrics, s.config.Service.Metrics) {
+		s.logger.Warn("config changes other than service.subscribers, kafka.applications and kafka.topics require a restart, ignoring them")
+	}
+
+	// new subscribers come with new kafka.applications entries, so the Kafka client is rebuilt with them
+	kafkaConfig := &cconfig.KafkaConfig{
+		TLS:          s.config.Kafka.TLS,
+		SASL:         s.config.Kafka.SASL,
+		Clusters:     s.config.Kafka.Clusters,
+		Version:      s.config.Kafka.Version,
+		Topics:       newConfig.Kafka.Topics,
+		Applications: newConfig.Kafka.Applications,
+	}
+	effectiveConfig := *newConfig
+	effectiveConfig.Kafka = *kafkaConfig
+	if err := ValidateConfig(&effectiveConfig); err != nil {
+		s.logger.Error("rejected config reload, invalid config with the Kafka connection settings requiring a restart", tag.Error(err))
+		return
+	}
+	kafkaClient := s.kafkaClient
+	if !reflect.DeepEqual(kafkaConfig.Topics, s.config.Kafka.Topics) || !reflect.DeepEqual(kafkaConfig.Applications, s.config.Kafka.Applications) {
+		kafkaClient = kafka.NewKafkaClient(kafkaConfig, s.metricsClient, s.logger, s.metricScope, false)
+	}
+
+	desired := make(map[string]*config.Subscriber)
+	for i := range newConfig.Service.Subscribers {
+		subscriber := newConfig.Service.Subscribers[i]
+		if _, ok := desired[subscriber.Name]; ok {
+			s.logger.Error("rejected config reload", tag.Error(fmt.Errorf("duplicate subscriber name %q", subscriber.Name)))
+			return
+		}
+		desired[subscriber.Name] = &subscriber
+	}
+
+	// create all the new notifiers before touching the running ones, so that an invalid config changes nothing
+	created := make(map[string]*notifier)
+	for name, subscriber := range desired {
+		if running, ok := s.notifiers[name]; ok && reflect.DeepEqual(running.subscriberConfig, subscriber) &&
+			!isSubscriberKafkaConfigChanged(running.kafkaConfig, kafkaConfig, subscriber) {
+			continue
+		}
+		n, err := s.newNotifier(kafkaClient, kafkaConfig, subscriber)
+		if err != nil {
+			for _, n := range created {
+				n.Stop()
+			}
+			s.logger.Error("rejected config reload", tag.Error(err))
+			return
+		}
+		created[name] = n
+	}
+	pauseStates, err := s.loadPauseStates()
+	if err != nil {
+		for _, n := range created {
+			n.Stop()
+		}
+		s.logger.Error("rejected config reload, failed to load subscriber state file", tag.Error(err))
+		return
+	}
+
+	// a changed subscriber is stopped before its replacement starts, as they share the consumer group and state files.
+	// If the replacement fails to start, the subscriber is restarted with its previous config.
+	replaced := make(map[string]*notifier)
+	for name, n := range s.notifiers {
+		if _, ok := desired[name]; ok {
+			if _, changed := created[name]; !changed {
+				continue
+			}
+			s.logger.Info("restarting changed subscriber", tag.Name(name))
+			replaced[name] = n
+		} else {
+			s.logger.Info("stopping removed subscriber", tag.Name(name))
+			if s.stateStore != nil {
+				if err := s.stateStore.set(name, ""); err != nil {
+					s.logger.Warn("failed to clear state of removed subscriber", tag.Error(err))
+				}
+			}
+		}
+		s.drainAndStopNotifier(n)
+	}
+	var failed []string
+	for name, n := range created {
+		s.logger.Info("starting subscriber", tag.Name(name))
+		if err := s.startNotifier(n, pauseStates); err != nil {
+			failed = append(failed, name)
+			s.logger.Error("failed to start subscriber after config reload", tag.Name(name), tag.Error(err))
+			if old, ok := replaced[name]; ok {
+				s.restoreNotifier(old, pauseStates)
+			}
+		}
+	}
+
+	// running notifiers keep the Kafka client and config they were created with
+	s.kafkaClient = kafkaClient
+	runningConfig := *s.config
+	runningConfig.Kafka = *kafkaConfig
+	runningConfig.Service.Subscribers = s.getRunningSubscribers(newConfig.Service.Subscribers)
+	s.config = &runningConfig
+	if s.admin != nil {
+		s.admin.setConfig(s.config)
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		s.logger.Error("config reload failed, subscribers failing to start kept their previous config or stay stopped",
+			tag.Value(failed))
+		return
+	}
+	s.logger.Info("config reloaded", tag.Counter(len(created)))
+}
+
+// restoreNotifier restarts a subscriber with the config of its stopped notifier
+func (s *Service) restoreNotifier(old *notifier, pauseStates map[string]string) {
+	name := old.subscriberConfig.Name
+	n, err := s.newNotifier(old.kafkaClient, old.kafkaConfig, old.subscriberConfig)
+	if err == nil {
+		err = s.startNotifier(n, pauseStates)
+	}
+	if err != nil {
+		s.logger.Error("failed to restart subscriber with its previous config, it stays stopped", tag.Name(name), tag.Error(err))
+		return
+	}
+	s.logger.Info("restarted subscriber with its previous config", tag.Name(name))
+}
+
+// getRunningSubscribers returns the configs of the running notifiers, in the order of the loaded subscribers
+func (s *Service) getRunningSubscribers(loaded []config.Subscriber) []config.Subscriber {
+	subscribers := make([]config.Subscriber, 0, len(s.notifiers))
+	for _, subscriber := range loaded {
+		if n, ok := s.notifiers[subscriber.Name]; ok {
+			subscribers = append(subscribers, *n.subscriberConfig)
+		}
+	}
+	return subscribers
+}
+
+// isSubscriberKafkaConfigChanged returns true if the topics the subscriber consumes from or publishes to are changed
+func isSubscriberKafkaConfigChanged(running, loaded *cconfig.KafkaConfig, subscriber *config.Subscriber) bool {
+	application := running.Applications[subscriber.Name]
+	if application != loaded.Applications[subscriber.Name] {
+		return true
+	}
+	for _, topic := range []string{
+		application.Topic,
+		application.DLQTopic,
+		subscriber.Consumer.ConsumerGroupDlqTopic,
+		subscriber.Delivery.Kafka.Topic,
+	} {
+		if topic != "" && running.Topics[topic] != loaded.Topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// isKafkaConnectionChanged returns true if the changes of the Kafka config are not only applications and topics
+func isKafkaConnectionChanged(running, loaded *cconfig.KafkaConfig) bool {
+	return !reflect.DeepEqual(running.TLS, loaded.TLS) ||
+		!reflect.DeepEqual(running.SASL, loaded.SASL) ||
+		running.Version != loaded.Version ||
+		!reflect.DeepEqual(normalizeBrokers(running.Clusters), normalizeBrokers(loaded.Clusters))
+}
+
+// normalizeBrokers adds the default port to brokers, as the Kafka client does to the running config
+func normalizeBrokers(clusters map[string]cconfig.ClusterConfig) map[string][]string {
+	brokers := make(map[string][]string, len(clusters))
+	for name, cluster := range clusters {
+		for _, broker := range cluster.Brokers {
+			if !strings.Contains(broker, ":") {
+				broker += ":9092"
+			}
+			brokers[name] = append(brokers[name], broker)
+		}
+	}
+	return brokers
+}
+
+// fingerprintDir returns a hash of the files in the directory for detecting changes
+func fingerprintDir(dir string) (string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%v:%v:", file.Name(), len(content))
+		hash.Write(content)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/uber-go/tally"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log/loggerimpl"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+// failingSink fails to start
+type failingSink struct {
+	fakeSink
+}
+
+func (s *failingSink) Start() error {
+	return errors.New("failed to connect")
+}
+
+func TestIsKafkaConnectionChanged(t *testing.T) {
+	newKafkaConfig := func(brokers ...string) *cconfig.KafkaConfig {
+		return &cconfig.KafkaConfig{
+			Clusters: map[string]cconfig.ClusterConfig{"test": {Brokers: brokers}},
+			Topics:   map[string]cconfig.TopicConfig{"visibility": {Cluster: "test"}},
+		}
+	}
+
+	running := newKafkaConfig("127.0.0.1:9092")
+	loaded := newKafkaConfig("127.0.0.1")
+	loaded.Topics["visibility-dlq"] = cconfig.TopicConfig{Cluster: "test"}
+	loaded.Applications = map[string]cconfig.TopicList{"new-subscriber": {Topic: "visibility", DLQTopic: "visibility-dlq"}}
+	if isKafkaConnectionChanged(running, loaded) {
+		t.Error("expected changes of applications and topics with the default broker port to be hot reloadable")
+	}
+
+	if !isKafkaConnectionChanged(running, newKafkaConfig("127.0.0.2:9092")) {
+		t.Error("expected a broker change to require a restart")
+	}
+	versioned := newKafkaConfig("127.0.0.1:9092")
+	versioned.Version = "2.0.0"
+	if !isKafkaConnectionChanged(running, versioned) {
+		t.Error("expected a version change to require a restart")
+	}
+}
+
+func TestIsSubscriberKafkaConfigChanged(t *testing.T) {
+	newKafkaConfig := func(dlqTopic string, dlqCluster string) *cconfig.KafkaConfig {
+		return &cconfig.KafkaConfig{
+			Topics: map[string]cconfig.TopicConfig{
+				"visibility":     {Cluster: "test"},
+				dlqTopic:         {Cluster: dlqCluster},
+				"subscriber-dlq": {Cluster: "test"},
+			},
+			Applications: map[string]cconfig.TopicList{"subscriber": {Topic: "visibility", DLQTopic: dlqTopic}},
+		}
+	}
+	subscriber := &config.Subscriber{Name: "subscriber"}
+	subscriber.Consumer.ConsumerGroupDlqTopic = "subscriber-dlq"
+
+	running := newKafkaConfig("visibility-dlq", "test")
+	unrelated := newKafkaConfig("visibility-dlq", "test")
+	unrelated.Topics["other"] = cconfig.TopicConfig{Cluster: "other"}
+	unrelated.Applications["other"] = cconfig.TopicList{Topic: "other"}
+	if isSubscriberKafkaConfigChanged(running, unrelated, subscriber) {
+		t.Error("expected changes of other applications not to restart the subscriber")
+	}
+	if !isSubscriberKafkaConfigChanged(running, newKafkaConfig("visibility-dlq2", "test"), subscriber) {
+		t.Error("expected a changed application dlq-topic to restart the subscriber")
+	}
+	if !isSubscriberKafkaConfigChanged(running, newKafkaConfig("visibility-dlq", "other"), subscriber) {
+		t.Error("expected a topic moved to another cluster to restart the subscriber")
+	}
+	moved := newKafkaConfig("visibility-dlq", "test")
+	moved.Topics["subscriber-dlq"] = cconfig.TopicConfig{Cluster: "other"}
+	if !isSubscriberKafkaConfigChanged(running, moved, subscriber) {
+		t.Error("expected a moved consumerGroupDlqTopic to restart the subscriber")
+	}
+}
+
+func TestStartNotifierFailure(t *testing.T) {
+	subscriber := &config.Subscriber{Name: "test"}
+	subscriber.Consumer.InitialOffset = initialOffsetOldest
+	logger := loggerimpl.NewNopLogger()
+	n, err := newNotifierWithSink(&cconfig.KafkaConfig{}, subscriber, nil, &failingSink{}, logger, tally.NoopScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{
+		logger:    logger,
+		admin:     newAdminServer(&config.Config{}, nil, logger),
+		notifiers: make(map[string]*notifier),
+	}
+	if err := s.startNotifier(n, map[string]string{}); err == nil {
+		t.Fatal("expected the notifier to fail to start")
+	}
+	if len(s.notifiers) > 0 {
+		t.Error("expected the notifier not to be registered")
+	}
+	if notifiers, _ := s.admin.getNotifiers(); len(notifiers) > 0 {
+		t.Error("expected the notifier not to be visible to the admin server")
+	}
+	if atomic.LoadInt32(&n.isStopped) != 1 {
+		t.Error("expected the notifier to be stopped")
+	}
+}
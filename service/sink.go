@@ -0,0 +1,166 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/uber-go/tally"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+// DeliveryMethodWebhook is the default delivery method
+const DeliveryMethodWebhook = "webhook"
+
+type (
+	// Sink delivers notifications to a subscriber
+	Sink interface {
+		// Start is called before the first delivery
+		Start() error
+		// Stop is called after the last delivery
+		Stop()
+		// Deliver sends the notification, including any retries. It's called concurrently.
+		// A failed delivery should be returned as a *DeliveryError, it's then published to the subscriber's DLQ.
+		// The context is canceled when the notifier is stopping, and carries the DeliveryInfo of the notification.
+		Deliver(ctx context.Context, notification *Notification) error
+	}
+
+	// SinkFactory creates the sinks of a delivery method
+	SinkFactory interface {
+		// ValidateConfig checks the delivery config of the subscriber for this method
+		ValidateConfig(subscriber *config.Subscriber, kafkaConfig *cconfig.KafkaConfig) error
+		// NewSink creates a sink for the subscriber, the config has been validated
+		NewSink(params *SinkParams) (Sink, error)
+	}
+
+	// SinkParams contains the dependencies for creating a sink
+	SinkParams struct {
+		Subscriber  *config.Subscriber
+		KafkaConfig *cconfig.KafkaConfig
+		Logger      log.Logger
+		MetricScope tally.Scope
+	}
+
+	// concurrencyHinter is implemented by sinks that need a minimum number of concurrent deliveries, e.g. to fill batches
+	concurrencyHinter interface {
+		minConcurrency() int
+	}
+
+	// requestLimitedSink is implemented by sinks that may send multiple notifications per request, e.g. batches.
+	// limitRequests returns true if the sink applies the delivery limits per request, instead of the notifier per notification
+	requestLimitedSink interface {
+		limitRequests(limiter *deliveryLimiter) bool
+	}
+
+	// DeliveryInfo describes where a notification comes from, see DeliveryInfoFromContext
+	DeliveryInfo struct {
+		// Subscriber is the name of the subscriber
+		Subscriber string
+		// SourcePartition and SourceOffset locate the visibility message in the visibility topic
+		SourcePartition int32
+		SourceOffset    int64
+	}
+
+	deliveryInfoKey struct{}
+
+	// DeliveryError describes a delivery that failed after all attempts
+	DeliveryError struct {
+		// Err is the error of the last attempt
+		Err error
+		// Attempts is the number of attempts made
+		Attempts int
+		// StatusCode is the status code of the last response from the receiver, 0 if not applicable
+		StatusCode int
+	}
+)
+
+var (
+	sinkFactoriesLock sync.RWMutex
+	sinkFactories     = make(map[string]SinkFactory)
+)
+
+// RegisterSink makes a delivery method available for subscribers' delivery.method config.
+// It's meant to be called from init() of the file implementing the sink, and panics if the method is registered twice.
+func RegisterSink(method string, factory SinkFactory) {
+	sinkFactoriesLock.Lock()
+	defer sinkFactoriesLock.Unlock()
+	if _, ok := sinkFactories[method]; ok {
+		panic(fmt.Sprintf("sink for delivery method %q is already registered", method))
+	}
+	sinkFactories[method] = factory
+}
+
+// getSinkFactory returns the factory of the subscriber's delivery method, which defaults to webhook
+func getSinkFactory(delivery *config.Delivery) (SinkFactory, error) {
+	method := delivery.Method
+	if method == "" {
+		method = DeliveryMethodWebhook
+	}
+
+	sinkFactoriesLock.RLock()
+	defer sinkFactoriesLock.RUnlock()
+	factory, ok := sinkFactories[method]
+	if !ok {
+		methods := make([]string, 0, len(sinkFactories))
+		for m := range sinkFactories {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		return nil, fmt.Errorf("unknown delivery method %q, supported methods: %v", method, methods)
+	}
+	return factory, nil
+}
+
+// newSink validates the subscriber's delivery config and creates the sink
+func newSink(params *SinkParams) (Sink, error) {
+	factory, err := getSinkFactory(&params.Subscriber.Delivery)
+	if err != nil {
+		return nil, err
+	}
+	if err := factory.ValidateConfig(params.Subscriber, params.KafkaConfig); err != nil {
+		return nil, err
+	}
+	return factory.NewSink(params)
+}
+
+func withDeliveryInfo(ctx context.Context, info *DeliveryInfo) context.Context {
+	return context.WithValue(ctx, deliveryInfoKey{}, info)
+}
+
+// DeliveryInfoFromContext returns the DeliveryInfo of the notification passed to Sink.Deliver, nil if there is none
+func DeliveryInfoFromContext(ctx context.Context) *DeliveryInfo {
+	info, _ := ctx.Value(deliveryInfoKey{}).(*DeliveryInfo)
+	return info
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("delivery failed after %v attempt(s): %v", e.Attempts, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
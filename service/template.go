@@ -0,0 +1,245 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/uber/cadence/common"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+const contentTypeJSON = "application/json"
+
+type (
+	// webhookTemplates renders the request body and headers of a webhook from the notification
+	webhookTemplates struct {
+		// nil means the notification as JSON
+		body *template.Template
+		// header name -> template, in canonical header form
+		headers map[string]*template.Template
+	}
+)
+
+// templateFuncs are the functions available in body and header templates
+var templateFuncs = template.FuncMap{
+	// json encodes a value as JSON, e.g. {{json .SearchAttributes}}
+	"json": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+	// formatTime formats a *time.Time, time.Time or unix nanoseconds with a Go layout or one of
+	// "RFC3339", "RFC3339Nano", "unix" and "unixMilli", e.g. {{formatTime "RFC3339" .ClosedTimestamp}}.
+	// Nil and zero times are formatted as an empty string
+	"formatTime": formatTemplateTime,
+	// get returns the value of a map key, nil if it's missing, e.g. {{get .SearchAttributes "CustomKeywordField"}}
+	"get": func(m interface{}, key string) interface{} {
+		v := reflect.ValueOf(m)
+		if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		value := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+		if !value.IsValid() {
+			return nil
+		}
+		return value.Interface()
+	},
+	// default returns the value, or the default if the value is nil or empty, e.g. {{default "unknown" .DomainName}}
+	"default": func(defaultValue interface{}, value interface{}) interface{} {
+		if value == nil {
+			return defaultValue
+		}
+		v := reflect.ValueOf(value)
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if v.IsNil() {
+				return defaultValue
+			}
+		case reflect.String, reflect.Map, reflect.Slice, reflect.Array:
+			if v.Len() == 0 {
+				return defaultValue
+			}
+		}
+		return value
+	},
+}
+
+// newWebhookTemplates parses the templates of the webhook and renders them with a sample notification,
+// so that errors show up on startup. It returns nil if the webhook has no templates.
+func newWebhookTemplates(webhook *config.Webhook) (*webhookTemplates, error) {
+	if webhook.BodyTemplate == "" && len(webhook.HeaderTemplates) == 0 {
+		return nil, nil
+	}
+	t := &webhookTemplates{headers: make(map[string]*template.Template)}
+	if webhook.BodyTemplate != "" {
+		body, err := template.New("bodyTemplate").Funcs(templateFuncs).Parse(webhook.BodyTemplate)
+		if err != nil {
+			return nil, err
+		}
+		t.body = body
+	}
+	names := make([]string, 0, len(webhook.HeaderTemplates))
+	for name := range webhook.HeaderTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		header, err := template.New("headerTemplates." + name).Funcs(templateFuncs).Parse(webhook.HeaderTemplates[name])
+		if err != nil {
+			return nil, err
+		}
+		t.headers[http.CanonicalHeaderKey(name)] = header
+	}
+
+	sample := newSampleNotification()
+	body, err := t.renderBody(sample)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render a sample notification: %v", err)
+	}
+	headers, err := t.renderHeaders(sample)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render a sample notification: %v", err)
+	}
+	if t.body != nil && isJSONContentType(headers.Get("Content-Type")) && !json.Valid(body) {
+		return nil, fmt.Errorf("bodyTemplate renders invalid JSON for a sample notification, set a Content-Type header template if it's not JSON: %s", body)
+	}
+	return t, nil
+}
+
+// renderBody renders the body template, or the notification as JSON if there is none
+func (t *webhookTemplates) renderBody(notification *Notification) ([]byte, error) {
+	if t == nil || t.body == nil {
+		return json.Marshal(notification)
+	}
+	var buf bytes.Buffer
+	if err := t.body.Execute(&buf, notification); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderHeaders returns the Content-Type header and the rendered header templates
+func (t *webhookTemplates) renderHeaders(notification *Notification) (http.Header, error) {
+	header := make(http.Header)
+	header.Set("Content-Type", contentTypeJSON)
+	if t == nil {
+		return header, nil
+	}
+	for name, headerTemplate := range t.headers {
+		var buf bytes.Buffer
+		if err := headerTemplate.Execute(&buf, notification); err != nil {
+			return nil, err
+		}
+		value := strings.TrimSpace(buf.String())
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("header %v must not contain line breaks", name)
+		}
+		header.Set(name, value)
+	}
+	return header, nil
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return mediaType == contentTypeJSON || strings.HasSuffix(mediaType, "+json")
+}
+
+func formatTemplateTime(layout string, value interface{}) (string, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		t = *v
+	case time.Time:
+		t = v
+	case int64:
+		t = time.Unix(0, v)
+	case int:
+		t = time.Unix(0, int64(v))
+	default:
+		return "", fmt.Errorf("formatTime: unsupported value of type %T", value)
+	}
+	if t.IsZero() {
+		return "", nil
+	}
+
+	switch layout {
+	case "RFC3339":
+		return t.UTC().Format(time.RFC3339), nil
+	case "RFC3339Nano":
+		return t.UTC().Format(time.RFC3339Nano), nil
+	case "unix":
+		return fmt.Sprint(t.Unix()), nil
+	case "unixMilli":
+		return fmt.Sprint(t.UnixNano() / int64(time.Millisecond)), nil
+	default:
+		return t.UTC().Format(layout), nil
+	}
+}
+
+// newSampleNotification returns a closed workflow notification with every field set, for checking templates
+func newSampleNotification() *Notification {
+	startTime := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	closeTime := startTime.Add(time.Minute)
+	duration := closeTime.Sub(startTime)
+	var queueDelay time.Duration
+	return &Notification{
+		SchemaVersion:       NotificationSchemaVersion,
+		ID:                  "0-1",
+		IdempotencyKey:      newIdempotencyKey("sample-domain-id", "sample-run-id", common.RecordClosed, strconv.FormatInt(closeTime.UnixNano(), 10)),
+		VisibilityOperation: common.RecordClosed,
+		DomainID:            "sample-domain-id",
+		DomainName:          "sample-domain",
+		WorkflowID:          "sample-workflow-id",
+		RunID:               "sample-run-id",
+		WorkflowType:        "sample.Workflow",
+		StartedTimestamp:    &startTime,
+		ExecutionTimestamp:  &startTime,
+		ClosedTimestamp:     &closeTime,
+		CloseStatus:         "COMPLETED",
+		HistoryLength:       11,
+		TaskList:            "sample-task-list",
+		NumClusters:         1,
+		Duration:            &duration,
+		QueueDelay:          &queueDelay,
+		SearchAttributes: map[string]interface{}{
+			"WorkflowType": "sample.Workflow",
+			"StartTime":    startTime.UnixNano(),
+			"CloseTime":    closeTime.UnixNano(),
+			"CloseStatus":  int64(0),
+		},
+		Memo: map[string]interface{}{},
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	cconfig "github.com/uber/cadence/common/config"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+const (
+	initialOffsetNewest = "newest"
+	initialOffsetOldest = "oldest"
+)
+
+// ConfigErrors contains all the problems found by ValidateConfig
+type ConfigErrors []error
+
+// ValidateConfig checks the config for problems that would otherwise show up only at runtime, it returns ConfigErrors if any
+func ValidateConfig(cfg *config.Config) error {
+	var errs ConfigErrors
+	subscriberNames := make(map[string]bool)
+	consumerGroups := make(map[string]string)
+	dedupStateFiles := make(map[string]string)
+
+	for i := range cfg.Service.Subscribers {
+		subscriber := &cfg.Service.Subscribers[i]
+		name := subscriber.Name
+		if name == "" {
+			name = fmt.Sprintf("#%v", i)
+			errs = append(errs, fmt.Errorf("subscriber %v: name is required", name))
+		} else if subscriberNames[name] {
+			errs = append(errs, fmt.Errorf("subscriber %v: duplicate subscriber name", name))
+		}
+		subscriberNames[name] = true
+
+		group := subscriber.Consumer.ConsumerGroup
+		if group == "" {
+			errs = append(errs, fmt.Errorf("subscriber %v: consumer.consumerGroup is required", name))
+		} else if other, ok := consumerGroups[group]; ok {
+			errs = append(errs, fmt.Errorf("subscriber %v: consumer.consumerGroup %v is also used by subscriber %v", name, group, other))
+		} else {
+			consumerGroups[group] = name
+		}
+
+		if stateFile := subscriber.Dedup.StateFile; stateFile != "" {
+			if other, ok := dedupStateFiles[stateFile]; ok {
+				errs = append(errs, fmt.Errorf("subscriber %v: dedup.stateFile %v is also used by subscriber %v", name, stateFile, other))
+			} else {
+				dedupStateFiles[stateFile] = name
+			}
+		}
+
+		for _, err := range validateSubscriber(subscriber, cfg) {
+			errs = append(errs, fmt.Errorf("subscriber %v: %v", name, err))
+		}
+	}
+
+	if mappingFile := cfg.Service.DomainResolver.MappingFile; mappingFile != "" {
+		if _, err := newStaticDomainSource(mappingFile).LoadDomains(); err != nil {
+			errs = append(errs, fmt.Errorf("service.domainResolver: %v", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateSubscriber(subscriber *config.Subscriber, cfg *config.Config) []error {
+	var errs []error
+	kafkaConfig := &cfg.Kafka
+
+	application, ok := kafkaConfig.Applications[subscriber.Name]
+	if !ok {
+		errs = append(errs, fmt.Errorf("missing kafka.applications entry for the subscriber"))
+	} else {
+		errs = appendTopicErrors(errs, kafkaConfig, "kafka.applications topic", application.Topic)
+		errs = appendTopicErrors(errs, kafkaConfig, "kafka.applications dlq-topic", application.DLQTopic)
+	}
+	if dlqTopic := subscriber.Consumer.ConsumerGroupDlqTopic; dlqTopic != "" {
+		errs = appendTopicErrors(errs, kafkaConfig, "consumer.consumerGroupDlqTopic", dlqTopic)
+	}
+	if err := validateInitialOffset(subscriber.Consumer.InitialOffset); err != nil {
+		errs = append(errs, err)
+	}
+	if subscriber.Consumer.Concurrency < 0 {
+		errs = append(errs, fmt.Errorf("consumer.concurrency must not be negative"))
+	}
+	if err := validateOrdering(subscriber.Consumer.Ordering); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateMemoEncoding(subscriber.MemoEncoding); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateDedup(&subscriber.Dedup); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateFormat(subscriber); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateDeliveryLimits(&subscriber.Delivery.Limits); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateCircuitBreaker(&subscriber.Delivery.CircuitBreaker); err != nil {
+		errs = append(errs, err)
+	}
+
+	if factory, err := getSinkFactory(&subscriber.Delivery); err != nil {
+		errs = append(errs, err)
+	} else if err := factory.ValidateConfig(subscriber, kafkaConfig); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(subscriber.Filter.SelectedDomains) > 0 && cfg.Service.DomainResolver.MappingFile == "" {
+		errs = append(errs, fmt.Errorf("filter.selectedDomains requires service.domainResolver to be configured"))
+	}
+	if _, err := compileFilterExpression(subscriber.Filter.Expression); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func appendTopicErrors(errs []error, kafkaConfig *cconfig.KafkaConfig, field string, topic string) []error {
+	if topic == "" {
+		return append(errs, fmt.Errorf("%v is required", field))
+	}
+	if _, err := getBrokersForTopic(kafkaConfig, topic); err != nil {
+		return append(errs, fmt.Errorf("%v: %v", field, err))
+	}
+	return errs
+}
+
+func validateInitialOffset(initialOffset string) error {
+	_, _, err := parseInitialOffset(initialOffset)
+	return err
+}
+
+func validateOrdering(ordering string) error {
+	switch ordering {
+	case "", orderingWorkflowID, orderingRunID:
+		return nil
+	default:
+		return fmt.Errorf("unknown consumer.ordering %q, supported values: %v, %v", ordering, orderingWorkflowID, orderingRunID)
+	}
+}
+
+func validateMemoEncoding(memoEncoding string) error {
+	switch memoEncoding {
+	case "", memoEncodingRaw, memoEncodingJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown memoEncoding %q, supported values: %v, %v", memoEncoding, memoEncodingRaw, memoEncodingJSON)
+	}
+}
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	cconfig "github.com/uber/cadence/common/config"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+func newValidTestConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Kafka = cconfig.KafkaConfig{
+		Clusters: map[string]cconfig.ClusterConfig{"test": {Brokers: []string{"127.0.0.1"}}},
+		Topics: map[string]cconfig.TopicConfig{
+			"visibility":     {Cluster: "test"},
+			"visibility-dlq": {Cluster: "test"},
+		},
+		Applications: map[string]cconfig.TopicList{
+			"subscriber0": {Topic: "visibility", DLQTopic: "visibility-dlq"},
+			"subscriber1": {Topic: "visibility", DLQTopic: "visibility-dlq"},
+		},
+	}
+	for _, name := range []string{"subscriber0", "subscriber1"} {
+		subscriber := config.Subscriber{Name: name}
+		subscriber.Consumer.ConsumerGroup = name
+		subscriber.Delivery.Webhook.URL = url.URL{Scheme: "http", Host: "localhost:8080", Path: "/" + name}
+		cfg.Service.Subscribers = append(cfg.Service.Subscribers, subscriber)
+	}
+	return cfg
+}
+
+func TestValidateConfig(t *testing.T) {
+	if err := ValidateConfig(newValidTestConfig()); err != nil {
+		t.Fatalf("expected the config to be valid, got %v", err)
+	}
+
+	cfg := newValidTestConfig()
+	cfg.Service.Subscribers[0].Consumer.ConsumerGroup = "subscriber1"
+	cfg.Service.Subscribers[0].Consumer.Ordering = "domainID"
+	cfg.Service.Subscribers[0].Consumer.Concurrency = -1
+	cfg.Service.Subscribers[1].Consumer.ConsumerGroupDlqTopic = "missing"
+	cfg.Service.Subscribers[1].MemoEncoding = "base64"
+	cfg.Service.Subscribers = append(cfg.Service.Subscribers, config.Subscriber{})
+
+	err := ValidateConfig(cfg)
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+	expected := []string{
+		"subscriber subscriber0: unknown consumer.ordering",
+		"subscriber subscriber0: consumer.concurrency must not be negative",
+		"subscriber subscriber1: consumer.consumerGroup subscriber1 is also used by subscriber subscriber0",
+		"subscriber subscriber1: consumer.consumerGroupDlqTopic: missing kafka.topics config for topic missing",
+		"subscriber subscriber1: unknown memoEncoding",
+		"subscriber #2: name is required",
+		"subscriber #2: consumer.consumerGroup is required",
+		"subscriber #2: missing kafka.applications entry for the subscriber",
+		"subscriber #2: invalid webhook url",
+	}
+	for _, message := range expected {
+		found := false
+		for _, err := range errs {
+			found = found || strings.HasPrefix(err.Error(), message)
+		}
+		if !found {
+			t.Errorf("expected an error %q, got\n%v", message, errs)
+		}
+	}
+	if len(errs) != len(expected) {
+		t.Errorf("expected %v errors, got %v:\n%v", len(expected), len(errs), errs)
+	}
+}
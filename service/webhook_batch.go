@@ -0,0 +1,280 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+const (
+	defaultBatchMaxWait = 100 * time.Millisecond
+	// content type of batches of structured CloudEvents
+	contentTypeCloudEventsBatchJSON = "application/cloudevents-batch+json"
+)
+
+type (
+	// webhookBatcher collects the notifications being delivered concurrently into batches.
+	// Every notification waits for its own result, so that it's acked only after the receiver accepted it.
+	webhookBatcher struct {
+		maxSize int
+		maxWait time.Duration
+		send    func(ctx context.Context, items []*batchItem)
+
+		items  chan *batchItem
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	}
+
+	batchItem struct {
+		body   []byte
+		result chan error
+	}
+
+	// webhookBatchResponse is the optional response body of a batch request, rejecting individual notifications.
+	// Notifications that are not rejected are accepted
+	webhookBatchResponse struct {
+		Rejected []webhookBatchRejection `json:"rejected"`
+	}
+
+	webhookBatchRejection struct {
+		// index of the notification in the request array
+		Index int `json:"index"`
+		// status code deciding whether the notification is retried, same as the status code of a single request.
+		// Default to 500
+		StatusCode int    `json:"statusCode"`
+		Reason     string `json:"reason"`
+	}
+)
+
+func validateWebhookBatch(subscriber *config.Subscriber) error {
+	batch := &subscriber.Delivery.Webhook.Batch
+	if batch.MaxSize < 0 {
+		return fmt.Errorf("maxSize must not be negative")
+	}
+	if batch.MaxWait < 0 {
+		return fmt.Errorf("maxWait must not be negative")
+	}
+	if batch.MaxSize <= 1 {
+		return nil
+	}
+	// the headers of a batch request can't be rendered from a single notification
+	if len(subscriber.Delivery.Webhook.HeaderTemplates) > 0 {
+		return fmt.Errorf("headerTemplates can't be used with batching")
+	}
+	if subscriber.Format == formatCloudEvents && subscriber.CloudEvents.Mode == cloudEventsModeBinary {
+		return fmt.Errorf("binary mode CloudEvents can't be batched")
+	}
+	return nil
+}
+
+func newWebhookBatcher(cfg *config.WebhookBatch, send func(ctx context.Context, items []*batchItem)) *webhookBatcher {
+	maxWait := cfg.MaxWait
+	if maxWait <= 0 {
+		maxWait = defaultBatchMaxWait
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webhookBatcher{
+		maxSize: cfg.MaxSize,
+		maxWait: maxWait,
+		send:    send,
+		items:   make(chan *batchItem),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+func (b *webhookBatcher) start() {
+	b.wg.Add(1)
+	go b.batchLoop()
+}
+
+// stop cancels the requests in flight, it's called after the last delivery
+func (b *webhookBatcher) stop() {
+	b.cancel()
+	b.wg.Wait()
+}
+
+// submit adds the notification to the next batch and returns its result
+func (b *webhookBatcher) submit(ctx context.Context, body []byte) error {
+	item := &batchItem{body: body, result: make(chan error, 1)}
+	select {
+	case b.items <- item:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-item.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *webhookBatcher) batchLoop() {
+	defer b.wg.Done()
+
+	for {
+		var batch []*batchItem
+		select {
+		case <-b.ctx.Done():
+			return
+		case item := <-b.items:
+			batch = append(batch, item)
+		}
+
+		timer := time.NewTimer(b.maxWait)
+	collect:
+		for len(batch) < b.maxSize {
+			select {
+			case item := <-b.items:
+				batch = append(batch, item)
+			case <-timer.C:
+				break collect
+			case <-b.ctx.Done():
+				break collect
+			}
+		}
+		timer.Stop()
+
+		// batches are sent concurrently, the number in flight is bounded by the notifier's workers
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.send(b.ctx, batch)
+		}()
+	}
+}
+
+// minConcurrency makes the notifier run enough workers to fill a batch
+func (s *webhookSink) minConcurrency() int {
+	if s.batcher == nil {
+		return 0
+	}
+	return s.batcher.maxSize
+}
+
+// limitRequests makes rps and maxInFlight of the delivery limits count batch requests, as a batch can't be filled
+// if each notification takes an in-flight slot
+func (s *webhookSink) limitRequests(limiter *deliveryLimiter) bool {
+	if s.batcher == nil {
+		return false
+	}
+	s.requestLimiter = limiter
+	return true
+}
+
+// deliverBatched retries the notification on its own, a retry joins whatever batch is being collected
+func (s *webhookSink) deliverBatched(ctx context.Context, notification *Notification) error {
+	var body []byte
+	var err error
+	if s.cloudEvents != nil {
+		var event *encodedCloudEvent
+		if event, err = s.cloudEvents.encode(notification); err == nil {
+			body = event.body
+		}
+	} else {
+		body, err = s.templates.renderBody(notification)
+	}
+	if err != nil {
+		return &DeliveryError{Err: err, Attempts: 1}
+	}
+	return retryDelivery(ctx, s.retryPolicy, s.retryClassifier.isRetryable, s.metricScope, func(_ int) error {
+		return s.batcher.submit(ctx, body)
+	})
+}
+
+// sendBatch posts the notifications as a JSON array and reports the result of each one
+func (s *webhookSink) sendBatch(ctx context.Context, items []*batchItem) {
+	var body bytes.Buffer
+	body.WriteByte('[')
+	for i, item := range items {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(item.body)
+	}
+	body.WriteByte(']')
+
+	header := make(http.Header)
+	header.Set("Content-Type", contentTypeJSON)
+	if s.cloudEvents != nil {
+		header.Set("Content-Type", contentTypeCloudEventsBatchJSON)
+	}
+	header.Set(WebhookHeaderSubscriber, s.subscriberName)
+	header.Set(WebhookHeaderSchemaVersion, strconv.Itoa(NotificationSchemaVersion))
+	header.Set(WebhookHeaderBatchSize, strconv.Itoa(len(items)))
+
+	if s.requestLimiter != nil {
+		throttled, err := s.requestLimiter.acquire(ctx)
+		if err != nil {
+			for _, item := range items {
+				item.result <- err
+			}
+			return
+		}
+		if throttled {
+			s.metricScope.Counter(throttledDeliveries).Inc(int64(len(items)))
+		}
+		defer s.requestLimiter.release()
+	}
+	s.metricScope.Counter(webhookBatches).Inc(1)
+	respBody, err := s.sendMessageToWebhook(ctx, body.Bytes(), header)
+	if err != nil {
+		for _, item := range items {
+			item.result <- err
+		}
+		return
+	}
+
+	results := make([]error, len(items))
+	var response webhookBatchResponse
+	// any other response body accepts the whole batch
+	if json.Unmarshal(respBody, &response) == nil {
+		for _, rejection := range response.Rejected {
+			if rejection.Index < 0 || rejection.Index >= len(items) {
+				s.logger.Warn(fmt.Sprintf("ignoring rejection of unknown batch index %v", rejection.Index))
+				continue
+			}
+			if results[rejection.Index] != nil {
+				s.logger.Warn(fmt.Sprintf("ignoring duplicate rejection of batch index %v", rejection.Index))
+				continue
+			}
+			statusCode := rejection.StatusCode
+			if statusCode == 0 {
+				statusCode = http.StatusInternalServerError
+			}
+			results[rejection.Index] = fmt.Errorf("rejected by the receiver: %v: %w", rejection.Reason, &statusCodeError{statusCode: statusCode})
+		}
+	}
+	for i, item := range items {
+		item.result <- results[i]
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/uber-go/tally"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log/loggerimpl"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+)
+
+func TestBatchedDeliveryLimits(t *testing.T) {
+	var inFlight, maxInFlight, requests, delivered int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for max := atomic.LoadInt64(&maxInFlight); current > max && !atomic.CompareAndSwapInt64(&maxInFlight, max, current); {
+			max = atomic.LoadInt64(&maxInFlight)
+		}
+		size, _ := strconv.Atoi(r.Header.Get(WebhookHeaderBatchSize))
+		atomic.AddInt64(&requests, 1)
+		atomic.AddInt64(&delivered, int64(size))
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriber := &config.Subscriber{Name: "test"}
+	subscriber.Delivery.Webhook.URL = *serverURL
+	subscriber.Delivery.Webhook.Batch = config.WebhookBatch{MaxSize: 5, MaxWait: time.Second}
+	subscriber.Delivery.Limits.MaxInFlight = 1
+	logger := loggerimpl.NewNopLogger()
+	sink, err := newSink(&SinkParams{Subscriber: subscriber, Logger: logger, MetricScope: tally.NoopScope})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newNotifierWithSink(&cconfig.KafkaConfig{}, subscriber, nil, sink, logger, tally.NoopScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Stop()
+
+	// with maxInFlight limiting notifications, a batch would never get a second notification
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.deliver(context.Background(), &Notification{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if delivered != 10 {
+		t.Errorf("expected 10 notifications to be delivered, got %v", delivered)
+	}
+	if requests >= 10 {
+		t.Errorf("expected the notifications to be batched, got %v requests", requests)
+	}
+	if maxInFlight != 1 {
+		t.Errorf("expected maxInFlight to limit the requests to 1 at a time, got %v", maxInFlight)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		// expected status code of the rejection of each notification, 0 if accepted
+		expected []int
+	}{
+		{
+			name:     "full success",
+			response: `{"rejected":[]}`,
+			expected: []int{0, 0, 0},
+		},
+		{
+			name:     "empty body",
+			response: ``,
+			expected: []int{0, 0, 0},
+		},
+		{
+			name:     "partial rejection",
+			response: `{"rejected":[{"index":0,"statusCode":400,"reason":"invalid"},{"index":2,"reason":"unavailable"}]}`,
+			expected: []int{400, 0, 500},
+		},
+		{
+			name:     "malformed body",
+			response: `{"rejected":[{"index":0`,
+			expected: []int{0, 0, 0},
+		},
+		{
+			name:     "index out of range",
+			response: `{"rejected":[{"index":-1,"statusCode":400},{"index":3,"statusCode":400},{"index":1,"statusCode":503}]}`,
+			expected: []int{0, 503, 0},
+		},
+		{
+			name:     "duplicate index",
+			response: `{"rejected":[{"index":1,"statusCode":400},{"index":1,"statusCode":503}]}`,
+			expected: []int{0, 400, 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if size := r.Header.Get(WebhookHeaderBatchSize); size != "3" {
+					t.Errorf("expected batch size header 3, got %v", size)
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				body = string(b)
+				w.Write([]byte(test.response))
+			}))
+			defer server.Close()
+			serverURL, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			subscriber := &config.Subscriber{Name: "test"}
+			subscriber.Delivery.Webhook.URL = *serverURL
+			subscriber.Delivery.Webhook.Batch = config.WebhookBatch{MaxSize: 3}
+			sink, err := newSink(&SinkParams{Subscriber: subscriber, Logger: loggerimpl.NewNopLogger(), MetricScope: tally.NoopScope})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Stop()
+
+			items := []*batchItem{
+				{body: []byte(`{"id":0}`), result: make(chan error, 1)},
+				{body: []byte(`{"id":1}`), result: make(chan error, 1)},
+				{body: []byte(`{"id":2}`), result: make(chan error, 1)},
+			}
+			sink.(*webhookSink).sendBatch(context.Background(), items)
+
+			if expected := `[{"id":0},{"id":1},{"id":2}]`; body != expected {
+				t.Errorf("expected request body %v, got %v", expected, body)
+			}
+			for i, item := range items {
+				err := <-item.result
+				var statusErr *statusCodeError
+				switch {
+				case test.expected[i] == 0 && err != nil:
+					t.Errorf("expected notification %v to be accepted, got %v", i, err)
+				case test.expected[i] != 0 && !errors.As(err, &statusErr):
+					t.Errorf("expected notification %v to be rejected, got %v", i, err)
+				case test.expected[i] != 0 && statusErr.statusCode != test.expected[i]:
+					t.Errorf("expected notification %v to be rejected with %v, got %v", i, test.expected[i], statusErr.statusCode)
+				}
+			}
+		})
+	}
+}
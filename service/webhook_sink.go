@@ -0,0 +1,332 @@
+// Copyright (c) 2021 Cadence workflow OSS organization
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/uber-go/tally"
+	cconfig "github.com/uber/cadence/common/config"
+	"github.com/uber/cadence/common/log"
+
+	"github.com/cadence-oss/cadence-notification/common/config"
+	"github.com/cadence-oss/cadence-notification/common/signature"
+)
+
+// Headers of webhook requests, in addition to the signature header of package common/signature.
+// Batch requests only carry the subscriber, schema version and batch size, and the headers of webhook.headerSecrets.
+const (
+	// WebhookHeaderIdempotencyKey is Notification.IdempotencyKey, the same for all deliveries of a visibility event
+	WebhookHeaderIdempotencyKey = "Idempotency-Key"
+	// WebhookHeaderAttempt is the attempt number of the delivery, starting from 1
+	WebhookHeaderAttempt = "Cadence-Notification-Attempt"
+	// WebhookHeaderFirstAttemptTime is the time of the first attempt of the delivery, in RFC3339 format with nanoseconds
+	WebhookHeaderFirstAttemptTime = "Cadence-Notification-First-Attempt-Time"
+	// WebhookHeaderSubscriber is the name of the subscriber
+	WebhookHeaderSubscriber = "Cadence-Notification-Subscriber"
+	// WebhookHeaderSchemaVersion is Notification.SchemaVersion
+	WebhookHeaderSchemaVersion = "Cadence-Notification-Schema-Version"
+	// WebhookHeaderSourcePartition is the partition of the visibility message
+	WebhookHeaderSourcePartition = "Cadence-Notification-Source-Partition"
+	// WebhookHeaderSourceOffset is the offset of the visibility message
+	WebhookHeaderSourceOffset = "Cadence-Notification-Source-Offset"
+	// WebhookHeaderBatchSize is the number of notifications of a batch request
+	WebhookHeaderBatchSize = "Cadence-Notification-Batch-Size"
+)
+
+// Values of the response class tag of the webhookResponses metric
+const (
+	responseClassTag = "response_class"
+
+	responseClassSuccess        = "success"
+	responseClassRedirect       = "redirect"
+	responseClassRejected       = "rejected"
+	responseClassThrottled      = "throttled"
+	responseClassServerError    = "server_error"
+	responseClassTransportError = "transport_error"
+)
+
+// max bytes of a rejected response read before closing it, so that the connection can be reused
+const maxDrainedResponseBody = 64 << 10
+
+type (
+	webhookSinkFactory struct{}
+
+	// webhookSink delivers notifications by POSTing them as JSON to the subscriber's URL
+	webhookSink struct {
+		subscriberName string
+		webhook        *config.Webhook
+		url            string
+		// for errors and logs, as the URL may carry a token
+		redactedURL     string
+		httpClient      *http.Client
+		retryPolicy     *retryPolicy
+		retryClassifier *retryClassifier
+		// requests are signed if not empty
+		signingSecrets [][]byte
+		// headers of webhook.headerSecrets, set on all requests
+		secretHeaders http.Header
+		// nil if the webhook has no templates
+		templates *webhookTemplates
+		// nil if the subscriber's format isn't cloudevents
+		cloudEvents *cloudEventsEncoder
+		// nil if batching is disabled
+		batcher *webhookBatcher
+		// the notifier's limiter applied to batch requests, nil if the notifier applies it
+		requestLimiter *deliveryLimiter
+
+		logger      log.Logger
+		metricScope tally.Scope
+	}
+)
+
+var _ Sink = (*webhookSink)(nil)
+
+func init() {
+	RegisterSink(DeliveryMethodWebhook, &webhookSinkFactory{})
+}
+
+func (f *webhookSinkFactory) ValidateConfig(subscriber *config.Subscriber, _ *cconfig.KafkaConfig) error {
+	webhook := &subscriber.Delivery.Webhook
+	if _, err := webhook.LoadURL(); err != nil {
+		return fmt.Errorf("invalid webhook url: %v", err)
+	}
+	if _, err := newRetryPolicy(&webhook.RetryPolicy); err != nil {
+		return fmt.Errorf("invalid webhook retry policy: %v", err)
+	}
+	if _, err := newRetryClassifier(webhook); err != nil {
+		return fmt.Errorf("invalid webhook config: %v", err)
+	}
+	if _, err := webhook.Signing.LoadSecrets(); err != nil {
+		return fmt.Errorf("invalid webhook signing config: %v", err)
+	}
+	if _, err := webhook.LoadHeaderSecrets(); err != nil {
+		return fmt.Errorf("invalid webhook header config: %v", err)
+	}
+	if _, err := newWebhookTemplates(webhook); err != nil {
+		return fmt.Errorf("invalid webhook template: %v", err)
+	}
+	if subscriber.Format == formatCloudEvents && webhook.BodyTemplate != "" {
+		return fmt.Errorf("webhook.bodyTemplate can't be used with format %v", formatCloudEvents)
+	}
+	if err := validateWebhookBatch(subscriber); err != nil {
+		return fmt.Errorf("invalid webhook batch config: %v", err)
+	}
+	return nil
+}
+
+func (f *webhookSinkFactory) NewSink(params *SinkParams) (Sink, error) {
+	webhook := &params.Subscriber.Delivery.Webhook
+	retryPolicy, err := newRetryPolicy(&webhook.RetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+	retryClassifier, err := newRetryClassifier(webhook)
+	if err != nil {
+		return nil, err
+	}
+	callbackURL, err := webhook.LoadURL()
+	if err != nil {
+		return nil, err
+	}
+	signingSecrets, err := webhook.Signing.LoadSecrets()
+	if err != nil {
+		return nil, err
+	}
+	secretHeaders, err := webhook.LoadHeaderSecrets()
+	if err != nil {
+		return nil, err
+	}
+	templates, err := newWebhookTemplates(webhook)
+	if err != nil {
+		return nil, err
+	}
+	cloudEvents, err := newCloudEventsEncoder(params.Subscriber)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{Timeout: webhook.CallbackRequestTimeout}
+	if !webhook.FollowRedirects {
+		// return the 3xx response so that it's reported as a config error
+		httpClient.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	sink := &webhookSink{
+		subscriberName:  params.Subscriber.Name,
+		webhook:         webhook,
+		url:             callbackURL.String(),
+		redactedURL:     webhook.RedactedURL(),
+		httpClient:      httpClient,
+		retryPolicy:     retryPolicy,
+		retryClassifier: retryClassifier,
+		signingSecrets:  signingSecrets,
+		secretHeaders:   secretHeaders,
+		templates:       templates,
+		cloudEvents:     cloudEvents,
+		logger:          params.Logger,
+		metricScope:     params.MetricScope,
+	}
+	if webhook.Batch.MaxSize > 1 {
+		sink.batcher = newWebhookBatcher(&webhook.Batch, sink.sendBatch)
+	}
+	return sink, nil
+}
+
+func (s *webhookSink) Start() error {
+	if s.batcher != nil {
+		s.batcher.start()
+	}
+	return nil
+}
+
+func (s *webhookSink) Stop() {
+	if s.batcher != nil {
+		s.batcher.stop()
+	}
+	s.httpClient.CloseIdleConnections()
+}
+
+// breaksPerAttempt is true as Deliver retries with retryDelivery
+func (s *webhookSink) breaksPerAttempt() bool {
+	return true
+}
+
+func (s *webhookSink) Deliver(ctx context.Context, notification *Notification) error {
+	if s.batcher != nil {
+		return s.deliverBatched(ctx, notification)
+	}
+	body, header, err := s.encode(notification)
+	if err != nil {
+		return &DeliveryError{Err: err, Attempts: 1}
+	}
+	header.Set(WebhookHeaderIdempotencyKey, notification.IdempotencyKey)
+	header.Set(WebhookHeaderFirstAttemptTime, time.Now().UTC().Format(time.RFC3339Nano))
+	header.Set(WebhookHeaderSchemaVersion, strconv.Itoa(notification.SchemaVersion))
+	if info := DeliveryInfoFromContext(ctx); info != nil {
+		header.Set(WebhookHeaderSubscriber, info.Subscriber)
+		header.Set(WebhookHeaderSourcePartition, strconv.Itoa(int(info.SourcePartition)))
+		header.Set(WebhookHeaderSourceOffset, strconv.FormatInt(info.SourceOffset, 10))
+	}
+	return retryDelivery(ctx, s.retryPolicy, s.retryClassifier.isRetryable, s.metricScope, func(attempt int) error {
+		attemptHeader := header.Clone()
+		attemptHeader.Set(WebhookHeaderAttempt, strconv.Itoa(attempt))
+		_, err := s.sendMessageToWebhook(ctx, body, attemptHeader)
+		return err
+	})
+}
+
+// encode returns the request body and headers of the notification, in the subscriber's format
+func (s *webhookSink) encode(notification *Notification) ([]byte, http.Header, error) {
+	header, err := s.templates.renderHeaders(notification)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.cloudEvents == nil {
+		body, err := s.templates.renderBody(notification)
+		return body, header, err
+	}
+
+	// HTTP protocol binding of CloudEvents
+	event, err := s.cloudEvents.encode(notification)
+	if err != nil {
+		return nil, nil, err
+	}
+	header.Set("Content-Type", event.contentType)
+	for name, value := range event.attributes {
+		header.Set("ce-"+name, value)
+	}
+	return event.body, header, nil
+}
+
+// sendMessageToWebhook returns the response body if the request is accepted with any 2xx.
+// 3xx and 4xx other than 429 are returned as statusCodeError, which are permanent unless configured as retryable,
+// and 429 and 503 carry the delay of their Retry-After header.
+func (s *webhookSink) sendMessageToWebhook(ctx context.Context, body []byte, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	for name, values := range s.secretHeaders {
+		req.Header[name] = values
+	}
+	if len(s.signingSecrets) > 0 {
+		// signed per attempt so that retries don't carry a stale timestamp
+		req.Header.Set(signature.Header, signature.Sign(body, time.Now(), s.signingSecrets))
+	}
+
+	s.logger.Debug("sending http request")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = s.redactedURL
+		}
+		s.logger.Error(err.Error())
+		if ctx.Err() == nil {
+			s.countResponse(responseClassTransportError)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainedResponseBody))
+	}
+
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		s.countResponse(responseClassSuccess)
+	case code >= 300 && code < 400:
+		s.countResponse(responseClassRedirect)
+		s.logger.Error(fmt.Sprintf("webhook responded with redirect %v to %q, fix webhook.url or enable webhook.followRedirects",
+			code, resp.Header.Get("Location")))
+		return nil, &statusCodeError{statusCode: code}
+	case code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable:
+		s.countResponse(responseClassThrottled)
+		return nil, &statusCodeError{statusCode: code, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	case code >= 400 && code < 500:
+		s.countResponse(responseClassRejected)
+		return nil, &statusCodeError{statusCode: code}
+	default:
+		s.countResponse(responseClassServerError)
+		return nil, &statusCodeError{statusCode: code}
+	}
+
+	s.logger.Debug(fmt.Sprintf("response Status: %v", resp.Status))
+	s.logger.Debug(fmt.Sprintf("response Headers: %v", resp.Header))
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	s.logger.Debug(fmt.Sprintf("response Body: %v", string(respBody)))
+	return respBody, nil
+}
+
+func (s *webhookSink) countResponse(class string) {
+	s.metricScope.Tagged(map[string]string{responseClassTag: class}).Counter(webhookResponses).Inc(1)
+}